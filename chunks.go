@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"github.com/lib/pq"
+	"strings"
+)
+
+// Порция таблицы при порционной передаче: строки с ключом больше After в порядке ключа,
+// не более Limit строк
+type tableChunk struct {
+	Key    columnInfo
+	After  *string // nil - с начала таблицы
+	Limit  int
+	Number int    // Номер порции в таблице
+	Done   int64  // Строк таблицы, переданных предыдущими порциями
+	Last   string // Ключ последней переданной строки, заполняется при передаче
+}
+
+// Контрольная точка порционной передачи. Записывается в журнал координатора вместе с
+// решением о фиксации порции, поэтому сохраняется только для зафиксированных порций
+type transferCheckpoint struct {
+	Table   string `json:"table"`
+	Chunk   int    `json:"chunk"`
+	LastKey string `json:"last_key"` // Ключ последней строки порции в текстовом представлении
+	Rows    int64  `json:"rows"`     // Строк таблицы, переданных с начала запуска
+}
+
+// Добавление к условию отбора границ порции. upToLast - ограничить сверху ключом
+// последней переданной строки (для удаления на источнике)
+func (c *tableChunk) restrict(filter string, upToLast bool) string {
+	if c == nil {
+		return filter
+	}
+	key := pq.QuoteIdentifier(c.Key.Name)
+	var conds []string
+	if filter != "" {
+		conds = append(conds, filter)
+	}
+	if c.After != nil {
+		conds = append(conds, fmt.Sprintf("%s > %s::%s", key, pq.QuoteLiteral(*c.After), c.Key.Type))
+	}
+	if upToLast {
+		conds = append(conds, fmt.Sprintf("%s <= %s::%s", key, pq.QuoteLiteral(c.Last), c.Key.Type))
+	}
+	return strings.Join(conds, " AND ")
+}
+
+// Порядок и ограничение выборки порции
+func (c *tableChunk) orderLimit() string {
+	if c == nil {
+		return ""
+	}
+	return fmt.Sprintf(" ORDER BY %s LIMIT %d", pq.QuoteIdentifier(c.Key.Name), c.Limit)
+}
+
+// Состояние запуска передачи по журналу координатора
+type transferRun struct {
+	Source      string
+	Destination string
+	Options     transferOptions
+	Checkpoint  *transferCheckpoint // Последняя зафиксированная порция, nil - порций ещё не было
+	Unfinished  []string            // GID, по которым транзакции ещё не завершены
+}
+
+// Чтение состояния запуска runID из журнала координатора
+func loadTransferRun(coord *coordinatorLog, runID string) (*transferRun, error) {
+	records, err := coord.records()
+	if err != nil {
+		return nil, err
+	}
+	var run *transferRun
+	for _, rec := range records {
+		if rec.RunID != runID {
+			continue
+		}
+		switch {
+		case rec.State == coordStarted && rec.Options != nil:
+			run = &transferRun{Source: rec.Source, Destination: rec.Destination, Options: *rec.Options}
+		case rec.State == coordCommit && rec.Checkpoint != nil && run != nil:
+			run.Checkpoint = rec.Checkpoint
+		}
+	}
+	if run == nil {
+		return nil, fmt.Errorf("Запуск %s не найден в журнале координатора %s", runID, coord.path)
+	}
+
+	outcomes, err := coord.outcomes()
+	if err != nil {
+		return nil, err
+	}
+	for gid, outcome := range outcomes {
+		if outcome.RunID == runID && !outcome.Done {
+			run.Unfinished = append(run.Unfinished, gid)
+		}
+	}
+	return run, nil
+}
+
+// Проверка, что таблицы можно передавать порциями: у каждой есть уникальный ключ без NULL
+// (иначе строки с одинаковым ключом на границе порции пропускаются), а при удалении
+// на источнике таблицы не связаны внешними ключами (родительские строки нельзя удалить
+// раньше дочерних, а дочерние нельзя вставить раньше родительских)
+func validateChunkedTables(q queryer, tables []*tableInfo, opts transferOptions) error {
+	for _, t := range tables {
+		key, err := opts.keyColumn(t)
+		if err != nil {
+			return err
+		}
+		if key.Generated != "" {
+			return fmt.Errorf("Столбец ключа %s таблицы %s вычисляемый и не подходит для порционной передачи", key.Name, t)
+		}
+		unique, err := uniqueColumn(q, t, key.Name)
+		if err != nil {
+			return err
+		}
+		if !unique || !key.NotNull {
+			return fmt.Errorf("Столбец ключа %s таблицы %s не является первичным ключом или уникальным столбцом NOT NULL и не подходит для порционной передачи", key.Name, t)
+		}
+		if opts.deletesSource() && len(t.References) > 0 {
+			return fmt.Errorf("Таблица %s ссылается на передаваемые таблицы, порционная передача в режиме %s невозможна", t, opts.mode())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+)
+
+// Журнал координатора запуска с зафиксированной первой порцией и подготовленной второй
+func chunkedRunLog(t *testing.T) (*coordinatorLog, string, []participantGID) {
+	t.Helper()
+	coord, err := openCoordinatorLog(filepath.Join(t.TempDir(), defaultCoordinatorLogName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	runID := newRunID()
+	opts := transferOptions{ChunkSize: 100}
+	if err := coord.write(coordinatorRecord{RunID: runID, State: coordStarted, Source: "a", Destination: "b", Options: &opts}); err != nil {
+		t.Fatal(err)
+	}
+	first := []participantGID{{Cluster: "a", GID: newGID(runID, "a") + "1"}, {Cluster: "b", GID: newGID(runID, "b") + "1"}}
+	checkpoint := &transferCheckpoint{Table: "public.t", Chunk: 1, LastKey: "100", Rows: 100}
+	for _, rec := range []coordinatorRecord{
+		{RunID: runID, State: coordPrepared, Participants: first},
+		{RunID: runID, State: coordCommit, Participants: first, Checkpoint: checkpoint},
+		{RunID: runID, State: coordDone, Participants: first},
+	} {
+		if err := coord.write(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	second := []participantGID{{Cluster: "a", GID: newGID(runID, "a") + "2"}, {Cluster: "b", GID: newGID(runID, "b") + "2"}}
+	if err := coord.append(runID, coordPrepared, second); err != nil {
+		t.Fatal(err)
+	}
+	return coord, runID, second
+}
+
+func TestTableChunkRestrict(t *testing.T) {
+	after := "100"
+	key := columnInfo{Name: "id", Type: "integer"}
+	tests := []struct {
+		name     string
+		chunk    *tableChunk
+		filter   string
+		upToLast bool
+		want     string
+	}{
+		{"no chunk", nil, "(status = 'new')", true, "(status = 'new')"},
+		{"first chunk", &tableChunk{Key: key, Last: "50"}, "", false, ""},
+		{"first chunk up to last", &tableChunk{Key: key, Last: "50"}, "", true, `"id" <= '50'::integer`},
+		{"next chunk", &tableChunk{Key: key, After: &after, Last: "200"}, "", false, `"id" > '100'::integer`},
+		{"next chunk up to last with filter", &tableChunk{Key: key, After: &after, Last: "200"}, "(status = 'new')", true,
+			`(status = 'new') AND "id" > '100'::integer AND "id" <= '200'::integer`},
+		{"quoted key", &tableChunk{Key: columnInfo{Name: "Order", Type: "text"}, After: &after, Last: "it's"}, "", true,
+			`"Order" > '100'::text AND "Order" <= 'it''s'::text`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.chunk.restrict(tt.filter, tt.upToLast); got != tt.want {
+				t.Errorf("restrict = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAbortedChunkCanBeResumed(t *testing.T) {
+	coord, runID, gids := chunkedRunLog(t)
+	// Порция откатывается до PREPARE TRANSACTION на участниках
+	if err := abortPrepared(coord, runID, gids, map[string]*sql.DB{}); err != nil {
+		t.Fatalf("abortPrepared: %v", err)
+	}
+	run, err := loadTransferRun(coord, runID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(run.Unfinished) != 0 {
+		t.Errorf("Unfinished = %v, want none", run.Unfinished)
+	}
+	if run.Checkpoint == nil || run.Checkpoint.Chunk != 1 || run.Checkpoint.LastKey != "100" {
+		t.Errorf("Checkpoint = %+v, want chunk 1 up to key 100", run.Checkpoint)
+	}
+}
+
+func TestFailedRollbackBlocksResume(t *testing.T) {
+	coord, runID, gids := chunkedRunLog(t)
+	// Сервер участника a недоступен: откат не выполняется, транзакцию завершит recover
+	db, err := sql.Open("postgres", "host=127.0.0.1 port=1 connect_timeout=1 sslmode=disable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := abortPrepared(coord, runID, gids, map[string]*sql.DB{gids[0].GID: db}); err == nil {
+		t.Fatal("abortPrepared: want error for unreachable server")
+	}
+	run, err := loadTransferRun(coord, runID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(run.Unfinished) != 1 || run.Unfinished[0] != gids[0].GID {
+		t.Errorf("Unfinished = %v, want [%s]", run.Unfinished, gids[0].GID)
+	}
+}
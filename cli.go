@@ -0,0 +1,837 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// Коды завершения неинтерактивного режима
+const (
+	exitOK         = 0 // Команда выполнена успешно
+	exitError      = 1 // Ошибка при выполнении команды
+	exitUsage      = 2 // Неверные аргументы командной строки
+	exitNotRunning = 3 // Сервер не запущен (как у pg_ctl status)
+	exitNotExists  = 4 // Сервер не существует по указанному пути
+	exitInDoubt    = 5 // Передача прервана, подготовленные транзакции ждут команды recover
+	exitVerify     = 6 // Передача откатена из-за расхождения при сверке
+)
+
+// Описание подкоманды: краткая справка и обработчик
+type command struct {
+	usage string
+	run   func(args []string) int
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"create":   {"создать сервер", cmdCreate},
+		"delete":   {"удалить сервер", cmdDelete},
+		"start":    {"запустить сервер", cmdStart},
+		"stop":     {"остановить сервер", cmdStop},
+		"status":   {"проверить статус сервера (код 0 - принимает подключения, 3 - не запущен, 4 - нет кластера)", cmdStatus},
+		"exists":   {"проверить наличие сервера (код 0 - существует, 4 - нет)", cmdExists},
+		"ports":    {"проверить, свободны ли порты серверов", cmdPorts},
+		"config":   {"показать (get), изменить (set) или сбросить (reset) параметр сервера", cmdConfig},
+		"hba":      {"показать (list), добавить (add) или удалить (remove) правила pg_hba.conf", cmdHba},
+		"tls":      {"включить TLS на сервере (enable) или выпустить сертификат клиента (client)", cmdTLS},
+		"transfer": {"выполнить передачу данных между серверами из инвентаря", cmdTransfer},
+		"migrate":  {"применить (up), откатить (down) миграции или показать их состояние (status)", cmdMigrate},
+		"generate": {"сгенерировать строки таблиц по описанию генерации", cmdGenerate},
+		"verify":   {"сверить таблицы источника и приёмника по числу строк и контрольным суммам", cmdVerify},
+		"prepared": {"показать подготовленные транзакции и запуски, которые их создали", cmdPrepared},
+		"recover":  {"завершить подготовленные транзакции по журналу координатора", cmdRecover},
+		"menu":     {"интерактивное меню", cmdMenu},
+	}
+}
+
+// Порядок вывода подкоманд в справке
+var commandOrder = []string{"create", "delete", "start", "stop", "status", "exists", "ports", "config", "hba", "tls", "migrate", "generate", "transfer", "verify", "prepared", "recover", "menu"}
+
+// Вывод справки по подкомандам
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Использование: DBA_Ali <команда> [флаги]")
+	fmt.Fprintln(w, "Без команды запускается интерактивное меню.")
+	fmt.Fprintln(w, "\nКоманды:")
+	for _, name := range commandOrder {
+		fmt.Fprintf(w, "  %-10s %s\n", name, commands[name].usage)
+	}
+	fmt.Fprintln(w, "\nСправка по флагам команды: DBA_Ali <команда> -h")
+}
+
+// Точка входа неинтерактивного режима. Возвращает код завершения процесса
+func runCLI(args []string) int {
+	if len(args) == 0 {
+		return cmdMenu(nil)
+	}
+	switch args[0] {
+	case "help", "-h", "-help", "--help":
+		printUsage(os.Stdout)
+		return exitOK
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Неизвестная команда: %s\n\n", args[0])
+		printUsage(os.Stderr)
+		return exitUsage
+	}
+	return cmd.run(args[1:])
+}
+
+// Флаги, общие для команд управления сервером
+type clusterFlags struct {
+	inventory string
+	cluster   string
+	path      string
+	host      string
+	port      int
+}
+
+// Регистрация флага пути к файлу инвентаря
+func inventoryFlag(fs *flag.FlagSet, path *string) {
+	fs.StringVar(path, "inventory", "", "файл инвентаря (по умолчанию $"+inventoryEnv+" или "+defaultInventoryFile+")")
+}
+
+// Создание набора флагов для команды управления сервером
+func newClusterFlagSet(name string) (*flag.FlagSet, *clusterFlags) {
+	cf := &clusterFlags{}
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	inventoryFlag(fs, &cf.inventory)
+	fs.StringVar(&cf.cluster, "cluster", "", "имя кластера из инвентаря (по умолчанию все кластеры)")
+	fs.StringVar(&cf.path, "path", "", "путь к кластеру вне инвентаря")
+	fs.StringVar(&cf.host, "host", "localhost", "хост сервера (вместе с -path)")
+	fs.IntVar(&cf.port, "port", 5432, "порт сервера (вместе с -path)")
+	return fs, cf
+}
+
+// Разбор флагов команды управления сервером и выбор кластеров. extra - регистрация
+// дополнительных флагов команды (может быть nil). Возвращает код завершения, если выполнение нужно прервать
+func parseClusterFlags(name string, args []string, extra func(fs *flag.FlagSet)) ([]Cluster, int, bool) {
+	fs, cf := newClusterFlagSet(name)
+	if extra != nil {
+		extra(fs)
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil, exitOK, false
+		}
+		return nil, exitUsage, false
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "Лишние аргументы: %v\n", fs.Args())
+		return nil, exitUsage, false
+	}
+	if cf.path != "" {
+		if cf.cluster != "" {
+			fmt.Fprintln(os.Stderr, "Флаги -path и -cluster несовместимы")
+			return nil, exitUsage, false
+		}
+		c := Cluster{Name: cf.path, DataDir: cf.path, Host: cf.host, Port: cf.port, Superuser: "postgres", PgBin: os.Getenv(pgBinEnv)}
+		if port, ok := allocatedPort(c); ok {
+			c.Port = port
+		}
+		return []Cluster{c}, exitOK, true
+	}
+	inv, err := loadInventory(cf.inventory)
+	if err != nil {
+		return nil, reportError(err), false
+	}
+	if cf.cluster == "" {
+		return inv.Clusters, exitOK, true
+	}
+	c, err := inv.Cluster(cf.cluster)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return nil, exitUsage, false
+	}
+	return []Cluster{c}, exitOK, true
+}
+
+// Выполнение действия над каждым кластером. Код завершения - ошибка, если хотя бы одно действие не удалось
+func forEachCluster(clusters []Cluster, action func(Cluster) error) int {
+	code := exitOK
+	for _, c := range clusters {
+		if err := action(c); err != nil {
+			code = reportError(err)
+		}
+	}
+	return code
+}
+
+// Вывод ошибки команды и получение кода завершения. Для ошибок передачи код зависит от этапа
+func reportError(err error) int {
+	if err == nil {
+		return exitOK
+	}
+	fmt.Fprintln(os.Stderr, redactSecrets(err.Error()))
+	switch {
+	case inDoubt(err):
+		return exitInDoubt
+	case failedPhase(err) == phaseVerify:
+		return exitVerify
+	}
+	return exitError
+}
+
+func cmdCreate(args []string) int {
+	var tls bool
+	var auth, authLocal, authHost string
+	clusters, code, ok := parseClusterFlags("create", args, func(fs *flag.FlagSet) {
+		fs.BoolVar(&tls, "tls", false, "включить TLS с сертификатом локального удостоверяющего центра")
+		fs.StringVar(&auth, "auth", "", "метод аутентификации для local и host: "+strings.Join(authMethods, ", ")+" (по умолчанию из инвентаря или trust)")
+		fs.StringVar(&authLocal, "auth-local", "", "метод аутентификации для подключений через Unix-сокет")
+		fs.StringVar(&authHost, "auth-host", "", "метод аутентификации для подключений по TCP")
+	})
+	if !ok {
+		return code
+	}
+	for i := range clusters {
+		c := &clusters[i]
+		c.TLS = c.TLS || tls
+		if auth != "" {
+			c.Auth, c.AuthLocal, c.AuthHost = auth, "", ""
+		}
+		for flagValue, field := range map[*string]*string{&authLocal: &c.AuthLocal, &authHost: &c.AuthHost} {
+			if *flagValue != "" {
+				*field = *flagValue
+			}
+		}
+		if _, _, err := c.authMethods(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitUsage
+		}
+	}
+	return forEachCluster(clusters, createCluser)
+}
+
+func cmdDelete(args []string) int {
+	clusters, code, ok := parseClusterFlags("delete", args, nil)
+	if !ok {
+		return code
+	}
+	return forEachCluster(clusters, deleteCluster)
+}
+
+// Регистрация флага времени ожидания запуска и остановки
+func timeoutFlag(fs *flag.FlagSet, timeout *time.Duration) {
+	fs.DurationVar(timeout, "timeout", 0, "время ожидания готовности сервера (по умолчанию из инвентаря, 60s)")
+}
+
+// Применение времени ожидания из флагов к кластерам
+func applyTimeout(clusters []Cluster, timeout time.Duration) {
+	if timeout <= 0 {
+		return
+	}
+	for i := range clusters {
+		clusters[i].Timeout = int(timeout.Round(time.Second).Seconds())
+	}
+}
+
+func cmdStart(args []string) int {
+	var timeout time.Duration
+	var allocate bool
+	clusters, code, ok := parseClusterFlags("start", args, func(fs *flag.FlagSet) {
+		timeoutFlag(fs, &timeout)
+		fs.BoolVar(&allocate, "allocate-port", false, "выделить следующий свободный порт, если заданный занят")
+	})
+	if !ok {
+		return code
+	}
+	applyTimeout(clusters, timeout)
+	if allocate {
+		for i := range clusters {
+			clusters[i].AllocatePort = true
+		}
+	}
+	return forEachCluster(clusters, StartCluster)
+}
+
+func cmdConfig(args []string) int {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		fmt.Fprintln(os.Stderr, "Использование: DBA_Ali config get|set|reset -name <параметр> [флаги]")
+		return exitUsage
+	}
+	action := args[0]
+	switch action {
+	case "get", "set", "reset":
+	default:
+		fmt.Fprintf(os.Stderr, "Неизвестное действие с параметром %q (допустимо: get, set, reset)\n", action)
+		return exitUsage
+	}
+	var name, value string
+	var noRestart bool
+	clusters, code, ok := parseClusterFlags("config "+action, args[1:], func(fs *flag.FlagSet) {
+		fs.StringVar(&name, "name", "", "имя параметра, например max_prepared_transactions")
+		if action == "set" {
+			fs.StringVar(&value, "value", "", "новое значение параметра")
+		}
+		if action != "get" {
+			fs.BoolVar(&noRestart, "no-restart", false, "не перезапускать сервер, если параметр требует перезапуска")
+		}
+	})
+	if !ok {
+		return code
+	}
+	if name == "" {
+		fmt.Fprintln(os.Stderr, "Не указан параметр -name")
+		return exitUsage
+	}
+	return forEachCluster(clusters, func(c Cluster) error {
+		if action == "get" {
+			return showServerParameter(c, name)
+		}
+		s, err := setServerParameter(c, "", name, value, action == "reset", !noRestart)
+		if err == nil && s.Name != "" {
+			fmt.Printf("Сервер %s: %s\n", c.Name, s)
+		}
+		return err
+	})
+}
+
+func cmdHba(args []string) int {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		fmt.Fprintln(os.Stderr, "Использование: DBA_Ali hba list|add|remove [флаги]")
+		return exitUsage
+	}
+	action := args[0]
+	switch action {
+	case "list", "add", "remove":
+	default:
+		fmt.Fprintf(os.Stderr, "Неизвестное действие pg_hba.conf %q (допустимо: list, add, remove)\n", action)
+		return exitUsage
+	}
+	var rule hbaRule
+	clusters, code, ok := parseClusterFlags("hba "+action, args[1:], func(fs *flag.FlagSet) {
+		if action == "list" {
+			return
+		}
+		fs.StringVar(&rule.Type, "type", "host", "тип подключения: "+strings.Join(hbaTypes, ", "))
+		fs.StringVar(&rule.Database, "database", "all", "БД правила")
+		fs.StringVar(&rule.User, "user", "all", "пользователь правила")
+		fs.StringVar(&rule.Address, "address", "", "адрес правила, например 127.0.0.1/32 (кроме local)")
+		fs.StringVar(&rule.Method, "method", "", "метод аутентификации: "+strings.Join(authMethods, ", ")+" (для remove - необязательно)")
+		if action == "add" {
+			fs.StringVar(&rule.Options, "options", "", "параметры метода, например clientcert=verify-full")
+		}
+	})
+	if !ok {
+		return code
+	}
+	if action == "add" {
+		if err := rule.validate(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return exitUsage
+		}
+	}
+	return forEachCluster(clusters, func(c Cluster) error {
+		return editHba(c, action, rule)
+	})
+}
+
+func cmdTLS(args []string) int {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		fmt.Fprintln(os.Stderr, "Использование: DBA_Ali tls enable|client [флаги]")
+		return exitUsage
+	}
+	action := args[0]
+	if action != "enable" && action != "client" {
+		fmt.Fprintf(os.Stderr, "Неизвестное действие TLS %q (допустимо: enable, client)\n", action)
+		return exitUsage
+	}
+	var days int
+	var user, out string
+	clusters, code, ok := parseClusterFlags("tls "+action, args[1:], func(fs *flag.FlagSet) {
+		fs.IntVar(&days, "days", 0, "срок действия сертификата в днях (по умолчанию 365)")
+		if action == "client" {
+			fs.StringVar(&user, "user", "", "пользователь, для которого выпускается сертификат")
+			fs.StringVar(&out, "out", "", "каталог сертификата и ключа (по умолчанию каталог удостоверяющего центра)")
+		}
+	})
+	if !ok {
+		return code
+	}
+	if action == "enable" {
+		return forEachCluster(clusters, func(c Cluster) error {
+			return enableTLS(c, days)
+		})
+	}
+	if user == "" {
+		fmt.Fprintln(os.Stderr, "Не указан пользователь -user")
+		return exitUsage
+	}
+	// Сертификат выпускается один раз для каждого удостоверяющего центра выбранных кластеров
+	issued := make(map[string]bool)
+	return forEachCluster(clusters, func(c Cluster) error {
+		if issued[c.tlsDir()] {
+			return nil
+		}
+		issued[c.tlsDir()] = true
+		return issueClientCert(c.tlsDir(), user, out, days)
+	})
+}
+
+func cmdPorts(args []string) int {
+	clusters, code, ok := parseClusterFlags("ports", args, nil)
+	if !ok {
+		return code
+	}
+	for _, c := range clusters {
+		if portAvailable(c, c.Port) {
+			fmt.Printf("Порт %d для сервера %s свободен\n", c.Port, c.Name)
+			continue
+		}
+		// Порт, занятый самим сервером, не считается конфликтом
+		if status, err := getClusterStatus(c); err == nil && status.State.alive() && status.Port == c.Port {
+			fmt.Printf("Порт %d занят сервером %s (PID %d)\n", c.Port, c.Name, status.PID)
+			continue
+		}
+		code = reportError(busyPortError(c, c.Port))
+	}
+	return code
+}
+
+func cmdStop(args []string) int {
+	var timeout time.Duration
+	var mode string
+	clusters, code, ok := parseClusterFlags("stop", args, func(fs *flag.FlagSet) {
+		timeoutFlag(fs, &timeout)
+		fs.StringVar(&mode, "mode", stopFast, "режим остановки: smart, fast, immediate")
+	})
+	if !ok {
+		return code
+	}
+	if err := validStopMode(mode); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitUsage
+	}
+	applyTimeout(clusters, timeout)
+	return forEachCluster(clusters, func(c Cluster) error {
+		return StopCluster(c, mode)
+	})
+}
+
+// Объединение кодов завершения по нескольким кластерам: ошибка важнее отсутствия сервера,
+// отсутствие сервера важнее остановленного сервера
+func worseExitCode(a, b int) int {
+	rank := map[int]int{exitOK: 0, exitNotRunning: 1, exitNotExists: 2, exitError: 3}
+	if rank[b] > rank[a] {
+		return b
+	}
+	return a
+}
+
+func cmdStatus(args []string) int {
+	clusters, code, ok := parseClusterFlags("status", args, nil)
+	if !ok {
+		return code
+	}
+	for _, c := range clusters {
+		code = worseExitCode(code, clusterStatusCode(c))
+	}
+	return code
+}
+
+// Вывод статуса кластера и получение кода завершения для него
+func clusterStatusCode(c Cluster) int {
+	if err := c.managed(); err != nil {
+		return reportError(err)
+	}
+	status, err := getClusterStatus(c)
+	if err != nil {
+		return reportError(err)
+	}
+	fmt.Printf("Сервер %s: %s.\n", c.Name, status)
+	switch status.State {
+	case StateNotACluster:
+		return exitNotExists
+	case StateStopped, StateStalePid, StateStarting, StateStopping:
+		return exitNotRunning
+	}
+	return exitOK
+}
+
+func cmdExists(args []string) int {
+	clusters, code, ok := parseClusterFlags("exists", args, nil)
+	if !ok {
+		return code
+	}
+	for _, c := range clusters {
+		if !ClusterExsists(c.DataDir) {
+			fmt.Printf("Сервер %s не существует по пути %s\n", c.Name, c.DataDir)
+			code = worseExitCode(code, exitNotExists)
+			continue
+		}
+		fmt.Printf("Сервер %s существует по пути %s\n", c.Name, c.DataDir)
+	}
+	return code
+}
+
+// Регистрация флагов подключения к серверу с суффиксом (a, b).
+// Незаданные флаги берутся из описания кластера в инвентаре
+func connParamsFlags(fs *flag.FlagSet, p *connParams, suffix string) {
+	fs.StringVar(&p.User, "user-"+suffix, "", "имя пользователя (по умолчанию из инвентаря)")
+	fs.StringVar(&p.Password, "password-"+suffix, "", "пароль (по умолчанию из инвентаря, PGPASSWORD или файла паролей; виден в списке процессов)")
+	fs.StringVar(&p.Host, "host-"+suffix, "", "хост (по умолчанию из инвентаря)")
+	fs.StringVar(&p.Port, "port-"+suffix, "", "порт (по умолчанию из инвентаря)")
+	fs.StringVar(&p.SSLMode, "sslmode-"+suffix, "", "режим SSL: disable, allow, prefer, require, verify-ca, verify-full (по умолчанию из инвентаря)")
+	fs.StringVar(&p.SSLRootCert, "sslrootcert-"+suffix, "", "корневой сертификат для проверки сервера")
+	fs.StringVar(&p.SSLCert, "sslcert-"+suffix, "", "сертификат клиента")
+	fs.StringVar(&p.SSLKey, "sslkey-"+suffix, "", "ключ сертификата клиента")
+}
+
+// Дополнение параметров подключения значениями из инвентаря
+func (p connParams) withDefaults(defaults connParams) connParams {
+	if p.User == "" {
+		p.User = defaults.User
+	}
+	if p.Password == "" {
+		p.Password = defaults.Password
+	}
+	registerSecret(p.Password)
+	if p.Host == "" {
+		p.Host = defaults.Host
+	}
+	if p.Port == "" {
+		p.Port = defaults.Port
+	}
+	for field, value := range map[*string]string{
+		&p.SSLMode: defaults.SSLMode, &p.SSLRootCert: defaults.SSLRootCert, &p.SSLCert: defaults.SSLCert, &p.SSLKey: defaults.SSLKey,
+	} {
+		if *field == "" {
+			*field = value
+		}
+	}
+	return p
+}
+
+// Флаги выбора серверов, таблиц и строк, общие для команд transfer и verify
+type selectionFlags struct {
+	inventoryPath, from, to          string
+	paramsA, paramsB                 connParams
+	database, tables, schema         string
+	where, keyColumn, keyFrom, keyTo string
+}
+
+func (f *selectionFlags) register(fs *flag.FlagSet) {
+	inventoryFlag(fs, &f.inventoryPath)
+	fs.StringVar(&f.from, "from", "", "кластер-источник из инвентаря (по умолчанию transfer.source)")
+	fs.StringVar(&f.to, "to", "", "кластер-приёмник из инвентаря (по умолчанию transfer.destination)")
+	connParamsFlags(fs, &f.paramsA, "a")
+	connParamsFlags(fs, &f.paramsB, "b")
+	fs.StringVar(&f.database, "database", "", "БД на обоих серверах (по умолчанию transfer.database или database)")
+	fs.StringVar(&f.tables, "tables", "", "таблицы через запятую в виде schema.table (по умолчанию transfer.tables или public.data)")
+	fs.StringVar(&f.schema, "schema", "", "все таблицы схемы")
+	fs.StringVar(&f.where, "where", "", "условие отбора строк на источнике")
+	fs.StringVar(&f.keyColumn, "key-column", "", "столбец диапазона ключей (по умолчанию первичный ключ)")
+	fs.StringVar(&f.keyFrom, "key-from", "", "нижняя граница ключа включительно")
+	fs.StringVar(&f.keyTo, "key-to", "", "верхняя граница ключа, не включая")
+}
+
+// Загрузка инвентаря, выбор участников и параметров передачи с учётом флагов.
+// resume - запуск, сервера которого используются, если -from и -to не заданы
+func (f *selectionFlags) resolve(resume string) (*Inventory, participant, participant, transferOptions, error) {
+	var a, b participant
+	inv, err := loadInventory(f.inventoryPath)
+	if err != nil {
+		return nil, a, b, transferOptions{}, err
+	}
+	from, to := f.from, f.to
+	if resume != "" && (from == "" || to == "") {
+		coord, err := openCoordinatorLog(inv.coordinatorLogPath())
+		if err != nil {
+			return nil, a, b, transferOptions{}, err
+		}
+		run, err := loadTransferRun(coord, resume)
+		if err != nil {
+			return nil, a, b, transferOptions{}, err
+		}
+		if from == "" {
+			from = run.Source
+		}
+		if to == "" {
+			to = run.Destination
+		}
+	}
+	if from == "" {
+		from = inv.Transfer.Source
+	}
+	if to == "" {
+		to = inv.Transfer.Destination
+	}
+	clusterA, err := inv.Cluster(from)
+	if err != nil {
+		return nil, a, b, transferOptions{}, err
+	}
+	clusterB, err := inv.Cluster(to)
+	if err != nil {
+		return nil, a, b, transferOptions{}, err
+	}
+	paramsA, paramsB := f.paramsA.withDefaults(clusterA.connParams()), f.paramsB.withDefaults(clusterB.connParams())
+	if err := paramsA.validate(); err != nil {
+		return nil, a, b, transferOptions{}, fmt.Errorf("Сервер %s: %v", clusterA.Name, err)
+	}
+	if err := paramsB.validate(); err != nil {
+		return nil, a, b, transferOptions{}, fmt.Errorf("Сервер %s: %v", clusterB.Name, err)
+	}
+	a = participant{Cluster: clusterA, Server: paramsA.conninfo()}
+	b = participant{Cluster: clusterB, Server: paramsB.conninfo()}
+
+	opts := inv.Transfer.transferOptions
+	if f.tables != "" || f.schema != "" {
+		opts.Tables, opts.Schema = nil, f.schema
+		if f.tables != "" {
+			opts.Tables = strings.Split(f.tables, ",")
+		}
+	}
+	for flagValue, option := range map[*string]*string{
+		&f.database: &opts.Database, &f.where: &opts.Where, &f.keyColumn: &opts.KeyColumn,
+		&f.keyFrom: &opts.KeyFrom, &f.keyTo: &opts.KeyTo,
+	} {
+		if *flagValue != "" {
+			*option = *flagValue
+		}
+	}
+	return inv, a, b, opts, nil
+}
+
+// Разбор флагов команды с выбором серверов и строк. extra - регистрация дополнительных флагов.
+// Возвращает код завершения, если выполнение нужно прервать
+func parseSelectionFlags(name string, args []string, selection *selectionFlags, extra func(fs *flag.FlagSet)) (int, bool) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	selection.register(fs)
+	extra(fs)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK, false
+		}
+		return exitUsage, false
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "Лишние аргументы: %v\n", fs.Args())
+		return exitUsage, false
+	}
+	return exitOK, true
+}
+
+func cmdMigrate(args []string) int {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		fmt.Fprintln(os.Stderr, "Использование: DBA_Ali migrate up|down|status [флаги]")
+		return exitUsage
+	}
+	action := args[0]
+	switch action {
+	case "up", "down", "status":
+	default:
+		fmt.Fprintf(os.Stderr, "Неизвестное действие миграций %q (допустимо: up, down, status)\n", action)
+		return exitUsage
+	}
+	var database, dir string
+	var steps int
+	clusters, code, ok := parseClusterFlags("migrate "+action, args[1:], func(fs *flag.FlagSet) {
+		fs.StringVar(&database, "database", defaultTransferDatabase, "БД, к которой применяются миграции")
+		fs.StringVar(&dir, "dir", "", "каталог миграций (по умолчанию встроенные миграции тестовых данных)")
+		fs.IntVar(&steps, "steps", 0, "сколько миграций применить или откатить (up: по умолчанию все, down: 1)")
+	})
+	if !ok {
+		return code
+	}
+	return forEachCluster(clusters, func(c Cluster) error {
+		return migrateCluster(c, database, dir, action, steps)
+	})
+}
+
+func cmdGenerate(args []string) int {
+	var database, specPath string
+	var rows int
+	var seed int64
+	var truncate bool
+	clusters, code, ok := parseClusterFlags("generate", args, func(fs *flag.FlagSet) {
+		fs.StringVar(&database, "database", defaultTransferDatabase, "БД, в которой генерируются строки")
+		fs.StringVar(&specPath, "spec", "", "файл описания генерации (по умолчанию строки таблицы Data)")
+		fs.IntVar(&rows, "rows", 0, "строк в каждой таблице (по умолчанию из описания)")
+		fs.Int64Var(&seed, "seed", 0, "начальное значение генератора случайных чисел (по умолчанию из описания или по времени)")
+		fs.BoolVar(&truncate, "truncate", false, "очистить таблицы перед генерацией")
+	})
+	if !ok {
+		return code
+	}
+	spec := fixtureSpec(0)
+	if specPath != "" {
+		var err error
+		if spec, err = loadGenerateSpec(specPath); err != nil {
+			return reportError(err)
+		}
+	}
+	spec = spec.withRows(rows)
+	if seed != 0 {
+		spec.Seed = seed
+	}
+	return forEachCluster(clusters, func(c Cluster) error {
+		return generateCluster(c, database, spec, truncate)
+	})
+}
+
+func cmdTransfer(args []string) int {
+	var selection selectionFlags
+	var method, mode, timeColumn, olderThan, onConflict, resume, fixtureSpec string
+	var batchSize, chunkSize, fixtureRows int
+	var verify, resetSequences, skipFixture, recreateFixture, configurePrepared, simulateCrash bool
+	code, ok := parseSelectionFlags("transfer", args, &selection, func(fs *flag.FlagSet) {
+		fs.StringVar(&method, "method", "", "способ записи на приёмнике: copy или insert (по умолчанию transfer.method или copy)")
+		fs.IntVar(&batchSize, "batch-size", 0, "строк в пакете (по умолчанию transfer.batch_size или 10000)")
+		fs.StringVar(&mode, "mode", "", "режим: move, copy или archive (по умолчанию transfer.mode или move)")
+		fs.StringVar(&timeColumn, "time-column", "", "столбец времени для режима archive")
+		fs.StringVar(&olderThan, "older-than", "", "граница архивирования: дата или возраст (90d, 12h)")
+		fs.StringVar(&onConflict, "on-conflict", "", "политика конфликтов ключа: fail, skip, overwrite или table (по умолчанию transfer.on_conflict или fail)")
+		fs.IntVar(&chunkSize, "chunk-size", 0, "строк в порции с отдельной двухфазной фиксацией (по умолчанию transfer.chunk_size, 0 - без порций)")
+		fs.StringVar(&resume, "resume", "", "возобновить прерванный порционный запуск с указанным идентификатором")
+		fs.BoolVar(&resetSequences, "reset-source-sequences", false, "сбросить последовательности источника после переноса строк")
+		fs.BoolVar(&verify, "verify", false, "сверить источник и приёмник перед подготовкой транзакций")
+		fs.BoolVar(&skipFixture, "skip-fixture", false, "не создавать тестовую БД и таблицу Data перед передачей")
+		fs.BoolVar(&recreateFixture, "recreate-fixture", false, "удалить и заново создать тестовую БД перед передачей")
+		fs.StringVar(&fixtureSpec, "fixture-spec", "", "описание генерации данных источника (по умолчанию transfer.fixture_spec или строки таблицы Data)")
+		fs.IntVar(&fixtureRows, "fixture-rows", 0, "строк в каждой таблице описания генерации (по умолчанию transfer.fixture_rows или из описания)")
+		fs.BoolVar(&configurePrepared, "configure-prepared", false, "включить подготовленные транзакции на управляемых кластерах и перезапустить их, если мест не хватает")
+		fs.BoolVar(&simulateCrash, "simulate-crash", false, "имитировать падение сервера B между PREPARE и COMMIT")
+	})
+	if !ok {
+		return code
+	}
+	inv, a, b, opts, err := selection.resolve(resume)
+	if err != nil {
+		return reportError(err)
+	}
+	for flagValue, option := range map[*string]*string{
+		&method: &opts.Method, &mode: &opts.Mode, &timeColumn: &opts.TimeColumn, &olderThan: &opts.OlderThan,
+		&onConflict: &opts.OnConflict, &fixtureSpec: &opts.FixtureSpec,
+	} {
+		if *flagValue != "" {
+			*option = *flagValue
+		}
+	}
+	if batchSize > 0 {
+		opts.BatchSize = batchSize
+	}
+	if chunkSize > 0 {
+		opts.ChunkSize = chunkSize
+	}
+	if fixtureRows > 0 {
+		opts.FixtureRows = fixtureRows
+	}
+	opts.Resume = resume
+	opts.Verify = opts.Verify || verify
+	opts.ResetSourceSequences = opts.ResetSourceSequences || resetSequences
+	opts.SkipFixture = opts.SkipFixture || skipFixture
+	opts.RecreateFixture = opts.RecreateFixture || recreateFixture
+	opts.ConfigurePrepared = opts.ConfigurePrepared || configurePrepared
+	opts.SimulateCrash = simulateCrash
+	return reportError(runTransfer(inv, a, b, opts))
+}
+
+func cmdVerify(args []string) int {
+	var selection selectionFlags
+	var ranges int
+	code, ok := parseSelectionFlags("verify", args, &selection, func(fs *flag.FlagSet) {
+		fs.IntVar(&ranges, "ranges", 0, "диапазонов ключа при поиске расхождений (по умолчанию transfer.verify_ranges или 16)")
+	})
+	if !ok {
+		return code
+	}
+	_, a, b, opts, err := selection.resolve("")
+	if err != nil {
+		return reportError(err)
+	}
+	if ranges > 0 {
+		opts.VerifyRanges = ranges
+	}
+	if err := opts.validate(time.Now()); err != nil {
+		return reportError(err)
+	}
+	return reportError(verifyServers(a.Server, b.Server, opts))
+}
+
+// Разбор флагов команды, работающей с журналом координатора. extra - регистрация
+// дополнительных флагов. Возвращает код завершения, если выполнение нужно прервать
+func parseCoordinatorFlags(name string, args []string, extra func(fs *flag.FlagSet)) (*Inventory, *coordinatorLog, int, bool) {
+	var inventoryPath, logPath string
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	inventoryFlag(fs, &inventoryPath)
+	fs.StringVar(&logPath, "log", "", "журнал координатора (по умолчанию coordinator_log из инвентаря)")
+	if extra != nil {
+		extra(fs)
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return nil, nil, exitOK, false
+		}
+		return nil, nil, exitUsage, false
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "Лишние аргументы: %v\n", fs.Args())
+		return nil, nil, exitUsage, false
+	}
+	inv, err := loadInventory(inventoryPath)
+	if err != nil {
+		return nil, nil, reportError(err), false
+	}
+	if logPath == "" {
+		logPath = inv.coordinatorLogPath()
+	}
+	coord, err := openCoordinatorLog(logPath)
+	if err != nil {
+		return nil, nil, reportError(err), false
+	}
+	return inv, coord, exitOK, true
+}
+
+func cmdRecover(args []string) int {
+	var dryRun bool
+	var minAge time.Duration
+	inv, coord, code, ok := parseCoordinatorFlags("recover", args, func(fs *flag.FlagSet) {
+		fs.BoolVar(&dryRun, "dry-run", false, "только показать, что будет сделано")
+		fs.DurationVar(&minAge, "min-age", defaultRecoverMinAge, "откатывать транзакции без решения, подготовленные не позднее этого времени назад")
+	})
+	if !ok {
+		return code
+	}
+	return reportError(recoverInDoubt(inv, coord, dryRun, minAge))
+}
+
+func cmdPrepared(args []string) int {
+	var runID string
+	inv, coord, code, ok := parseCoordinatorFlags("prepared", args, func(fs *flag.FlagSet) {
+		fs.StringVar(&runID, "run", "", "показать только транзакции указанного запуска")
+	})
+	if !ok {
+		return code
+	}
+	return reportError(listPrepared(inv, coord, runID))
+}
+
+func cmdMenu(args []string) int {
+	var inventoryPath string
+	fs := flag.NewFlagSet("menu", flag.ContinueOnError)
+	inventoryFlag(fs, &inventoryPath)
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return exitOK
+		}
+		return exitUsage
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "Лишние аргументы: %v\n", fs.Args())
+		return exitUsage
+	}
+	inv, err := loadInventory(inventoryPath)
+	if err != nil {
+		return reportError(err)
+	}
+	runMenu(inv)
+	return exitOK
+}
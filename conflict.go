@@ -0,0 +1,176 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"strings"
+)
+
+// Политики обработки строк, ключ которых уже есть на приёмнике
+const (
+	conflictFail      = "fail"      // Прервать передачу
+	conflictSkip      = "skip"      // Оставить строку приёмника, строка источника не удаляется
+	conflictOverwrite = "overwrite" // Заменить строку приёмника (INSERT ... ON CONFLICT DO UPDATE)
+	conflictTable     = "table"     // Записать строку источника в таблицу конфликтов <таблица>_conflicts
+)
+
+// Суффикс таблицы конфликтов и её служебные столбцы
+const (
+	conflictTableSuffix  = "_conflicts"
+	conflictRunColumn    = "dba_ali_run_id"
+	conflictTimeColumn   = "dba_ali_conflict_at"
+	uniqueViolationError = "23505"
+)
+
+// Результат записи строки на приёмнике
+type rowOutcome int
+
+const (
+	rowInserted rowOutcome = iota
+	rowSkipped
+	rowOverwritten
+	rowDiverted // Записана в таблицу конфликтов
+)
+
+// Политика обработки конфликтов
+func (o transferOptions) onConflict() string {
+	if o.OnConflict != "" {
+		return o.OnConflict
+	}
+	return conflictFail
+}
+
+// Понятное сообщение о конфликте ключа при политике fail
+func conflictError(t *tableInfo, err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == uniqueViolationError {
+		return fmt.Errorf("Строка таблицы %s уже есть на приёмнике (%s). Выберите политику конфликтов %s, %s или %s",
+			t, pqErr.Detail, conflictSkip, conflictOverwrite, conflictTable)
+	}
+	return err
+}
+
+// Имя таблицы конфликтов с экранированием для SQL
+func conflictTableName(t *tableInfo) string {
+	return pq.QuoteIdentifier(t.Schema) + "." + pq.QuoteIdentifier(t.Name+conflictTableSuffix)
+}
+
+// Создание таблицы конфликтов на приёмнике: столбцы таблицы без ограничений, запуск и время
+func ensureConflictTable(tx *sql.Tx, t *tableInfo) error {
+	_, err := tx.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (LIKE %s, %s text, %s timestamptz DEFAULT now())",
+		conflictTableName(t), t.qualified(), pq.QuoteIdentifier(conflictRunColumn), pq.QuoteIdentifier(conflictTimeColumn)))
+	if err != nil {
+		return fmt.Errorf("Ошибка создания таблицы конфликтов для %s: %v", t, err)
+	}
+	return nil
+}
+
+// Запись строк командой INSERT ... ON CONFLICT по политике skip, overwrite или table
+type conflictWriter struct {
+	tx     *sql.Tx
+	table  *tableInfo
+	server string // Имя приёмника для сообщений
+	policy string
+	runID  string
+	stmt   *sql.Stmt
+	divert *sql.Stmt
+}
+
+func (w *conflictWriter) begin() error {
+	if w.stmt != nil {
+		return nil
+	}
+	cols := w.table.dataColumns()
+	suffix := " ON CONFLICT DO NOTHING"
+	if w.policy == conflictOverwrite {
+		if len(w.table.PrimaryKey) == 0 {
+			return fmt.Errorf("У таблицы %s нет первичного ключа, политика %s невозможна", w.table, conflictOverwrite)
+		}
+		pk := make(map[string]bool)
+		var target []string
+		for _, name := range w.table.PrimaryKey {
+			pk[name] = true
+			target = append(target, pq.QuoteIdentifier(name))
+		}
+		var set []string
+		for _, c := range cols {
+			if !pk[c.Name] && c.Identity != "ALWAYS" {
+				set = append(set, fmt.Sprintf("%s = EXCLUDED.%s", pq.QuoteIdentifier(c.Name), pq.QuoteIdentifier(c.Name)))
+			}
+		}
+		action := "DO NOTHING"
+		if len(set) > 0 {
+			action = "DO UPDATE SET " + strings.Join(set, ", ")
+		}
+		// xmax = 0 у новой версии строки означает вставку, иначе строка была обновлена
+		suffix = fmt.Sprintf(" ON CONFLICT (%s) %s RETURNING (xmax = 0)", strings.Join(target, ", "), action)
+	}
+	stmt, err := prepareInsert(w.tx, w.table, w.server, suffix)
+	if err != nil {
+		return err
+	}
+	w.stmt = stmt
+
+	if w.policy == conflictTable {
+		if err := ensureConflictTable(w.tx, w.table); err != nil {
+			return err
+		}
+		placeholders := append(valuePlaceholders(cols), fmt.Sprintf("$%d", len(cols)+1))
+		divert, err := w.tx.Prepare(fmt.Sprintf("INSERT INTO %s (%s, %s) VALUES (%s)",
+			conflictTableName(w.table), columnList(cols, nil), pq.QuoteIdentifier(conflictRunColumn), strings.Join(placeholders, ", ")))
+		if err != nil {
+			return fmt.Errorf("Ошибка подготовки вставки в таблицу конфликтов %s: %v", w.table, err)
+		}
+		w.divert = divert
+	}
+	return nil
+}
+
+func (w *conflictWriter) write(values []any) (rowOutcome, error) {
+	if w.policy == conflictOverwrite {
+		var inserted bool
+		err := w.stmt.QueryRow(values...).Scan(&inserted)
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			// Строка из одних ключевых столбцов уже есть на приёмнике: заменять нечего
+			// (ON CONFLICT DO NOTHING), строка учитывается как пропущенная
+			return rowSkipped, nil
+		case err != nil:
+			return rowInserted, fmt.Errorf("Ошибка вставки данных в %s на сервере %s: %v", w.table, w.server, err)
+		case inserted:
+			return rowInserted, nil
+		}
+		return rowOverwritten, nil
+	}
+
+	res, err := w.stmt.Exec(values...)
+	if err != nil {
+		return rowInserted, fmt.Errorf("Ошибка вставки данных в %s на сервере %s: %v", w.table, w.server, err)
+	}
+	if n, err := res.RowsAffected(); err != nil || n > 0 {
+		return rowInserted, err
+	}
+	if w.policy == conflictSkip {
+		return rowSkipped, nil
+	}
+	if _, err := w.divert.Exec(append(values, w.runID)...); err != nil {
+		return rowInserted, fmt.Errorf("Ошибка записи в таблицу конфликтов %s: %v", w.table, err)
+	}
+	return rowDiverted, nil
+}
+
+func (w *conflictWriter) end() error {
+	return nil
+}
+
+func (w *conflictWriter) close() error {
+	if w.divert != nil {
+		w.divert.Close()
+	}
+	if w.stmt != nil {
+		return w.stmt.Close()
+	}
+	return nil
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Журнал координатора по умолчанию в каталоге данных тестовых серверов
+const defaultCoordinatorLogName = "coordinator.log"
+
+// Состояния глобальной транзакции в журнале координатора
+const (
+	coordPrepared = "prepared" // Записывается до PREPARE TRANSACTION на участниках
+	coordCommit   = "commit"   // Решение о фиксации, записывается до COMMIT PREPARED
+	coordAbort    = "abort"    // Решение об откате
+	coordDone     = "done"     // Все участники завершили транзакцию
+	coordStarted  = "started"  // Начало запуска передачи с его параметрами
+)
+
+// Подготовленная транзакция участника: имя кластера и GID
+type participantGID struct {
+	Cluster string `json:"cluster"`
+	GID     string `json:"gid"`
+}
+
+// Запись журнала координатора. Решение по всем участникам записывается одной строкой,
+// поэтому оно либо сохранено целиком, либо отсутствует
+type coordinatorRecord struct {
+	Time         time.Time        `json:"time"`
+	RunID        string           `json:"run_id"`
+	State        string           `json:"state"`
+	Participants []participantGID `json:"participants"`
+
+	// Параметры запуска (в записи started) и контрольная точка порции (в записи commit)
+	Source      string              `json:"source,omitempty"`
+	Destination string              `json:"destination,omitempty"`
+	Options     *transferOptions    `json:"options,omitempty"`
+	Checkpoint  *transferCheckpoint `json:"checkpoint,omitempty"`
+
+	// Процесс, сделавший запись: по нему recover отличает брошенный запуск от идущего
+	PID  int    `json:"pid,omitempty"`
+	Host string `json:"host,omitempty"`
+}
+
+// Блокировка журнала занята другим процессом
+var errLogLocked = errors.New("блокировка занята")
+
+// Имя текущего компьютера для записей журнала и блокировки
+func localHost() string {
+	host, _ := os.Hostname()
+	return host
+}
+
+// Журнал координатора двухфазной фиксации в локальном файле (по одной записи JSON в строке)
+type coordinatorLog struct {
+	path string
+	mu   sync.Mutex
+}
+
+// Путь к журналу координатора: из инвентаря или в каталоге по умолчанию
+func (inv *Inventory) coordinatorLogPath() string {
+	if inv.CoordinatorLog != "" {
+		return inv.CoordinatorLog
+	}
+	return filepath.Join(defaultDataRoot(), defaultCoordinatorLogName)
+}
+
+// Открытие журнала координатора. Каталог журнала создаётся при необходимости
+func openCoordinatorLog(path string) (*coordinatorLog, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("Ошибка создания каталога журнала координатора %s: %v", path, err)
+	}
+	return &coordinatorLog{path: path}, nil
+}
+
+// Добавление записи в журнал с принудительным сбросом на диск. runID - идентификатор запуска передачи
+func (l *coordinatorLog) append(runID, state string, participants []participantGID) error {
+	return l.write(coordinatorRecord{RunID: runID, State: state, Participants: participants})
+}
+
+// Запись произвольной записи журнала с принудительным сбросом на диск
+func (l *coordinatorLog) write(rec coordinatorRecord) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	rec.Time = time.Now()
+	rec.PID, rec.Host = os.Getpid(), localHost()
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("Ошибка открытия журнала координатора %s: %v", l.path, err)
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("Ошибка записи в журнал координатора %s: %v", l.path, err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return fmt.Errorf("Ошибка сброса журнала координатора %s на диск: %v", l.path, err)
+	}
+	return f.Close()
+}
+
+// Файл блокировки журнала. Журнал открывается заново при каждой записи, поэтому
+// блокируется отдельный файл рядом с ним
+func (l *coordinatorLog) lockPath() string {
+	return l.path + ".lock"
+}
+
+// Захват исключительной блокировки журнала на время раунда передачи или восстановления,
+// чтобы recover не принял решение по транзакциям идущей передачи. wait - ждать освобождения
+// блокировки, иначе вернуть ошибку. Возвращает функцию снятия блокировки
+func (l *coordinatorLog) lock(wait bool) (func(), error) {
+	f, err := os.OpenFile(l.lockPath(), os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка открытия файла блокировки журнала координатора %s: %v", l.lockPath(), err)
+	}
+	err = lockFile(f, false)
+	if errors.Is(err, errLogLocked) && wait {
+		fmt.Printf("Журнал координатора %s используется %s, ожидаю освобождения\n", l.path, l.lockOwner())
+		err = lockFile(f, true)
+	}
+	if err != nil {
+		f.Close()
+		if errors.Is(err, errLogLocked) {
+			return nil, fmt.Errorf("Журнал координатора %s используется %s", l.path, l.lockOwner())
+		}
+		return nil, fmt.Errorf("Ошибка блокировки журнала координатора %s: %v", l.path, err)
+	}
+	// Владелец блокировки записывается в файл только для сообщений другим процессам
+	if err := f.Truncate(0); err == nil {
+		f.WriteAt([]byte(fmt.Sprintf("%d %s\n", os.Getpid(), localHost())), 0)
+	}
+	return func() {
+		unlockFile(f)
+		f.Close()
+	}, nil
+}
+
+// Описание процесса, держащего блокировку журнала, для сообщений
+func (l *coordinatorLog) lockOwner() string {
+	data, err := os.ReadFile(l.lockPath())
+	if err != nil {
+		return "другим процессом"
+	}
+	pid, host, _ := strings.Cut(strings.TrimSpace(string(data)), " ")
+	if _, err := strconv.Atoi(pid); err != nil {
+		return "другим процессом"
+	}
+	if host == "" {
+		return "процессом " + pid
+	}
+	return fmt.Sprintf("процессом %s на %s", pid, host)
+}
+
+// Чтение всех записей журнала. Недописанная последняя строка (падение во время записи) пропускается
+func (l *coordinatorLog) records() ([]coordinatorRecord, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, err := os.Open(l.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("Ошибка чтения журнала координатора %s: %v", l.path, err)
+	}
+	defer f.Close()
+
+	var records []coordinatorRecord
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var rec coordinatorRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			continue
+		}
+		records = append(records, rec)
+	}
+	return records, scanner.Err()
+}
+
+// Известная координатору транзакция участника и принятое по ней решение
+type gidOutcome struct {
+	RunID       string
+	Participant participantGID
+	Decision    string // coordCommit, coordAbort или пустая строка, если решение не записано
+	Done        bool
+
+	// Время записи prepared и процесс передачи, который её сделал
+	Prepared time.Time
+	PID      int
+	Host     string
+}
+
+// Итог по каждому GID из журнала. Учитывается последняя запись с этим GID
+func (l *coordinatorLog) outcomes() (map[string]gidOutcome, error) {
+	records, err := l.records()
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]gidOutcome)
+	for _, rec := range records {
+		for _, p := range rec.Participants {
+			outcome := result[p.GID]
+			outcome.RunID = rec.RunID
+			outcome.Participant = p
+			switch rec.State {
+			case coordPrepared:
+				outcome = gidOutcome{RunID: rec.RunID, Participant: p, Prepared: rec.Time, PID: rec.PID, Host: rec.Host}
+			case coordCommit, coordAbort:
+				outcome.Decision = rec.State
+			case coordDone:
+				outcome.Done = true
+			}
+			result[p.GID] = outcome
+		}
+	}
+	return result, nil
+}
+
+// Проверка, что передача, подготовившая транзакцию без решения, уже не работает: её процесс
+// на этом компьютере завершился или запись prepared старше minAge. PID процесса на другом
+// компьютере проверить нельзя, там учитывается только возраст
+func (o gidOutcome) abandoned(now time.Time, minAge time.Duration) bool {
+	if o.PID != 0 && o.Host != "" && o.Host == localHost() && !processAlive(o.PID) {
+		return true
+	}
+	return now.Sub(o.Prepared) >= minAge
+}
+
+// Описание решения координатора для вывода
+func (o gidOutcome) String() string {
+	switch {
+	case o.Done:
+		return "завершена"
+	case o.Decision == coordCommit:
+		return "решение: фиксация"
+	case o.Decision == coordAbort:
+		return "решение: откат"
+	}
+	return "решение не записано"
+}
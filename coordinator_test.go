@@ -0,0 +1,81 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCoordinatorLockIsExclusive(t *testing.T) {
+	path := filepath.Join(t.TempDir(), defaultCoordinatorLogName)
+	first, err := openCoordinatorLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlock, err := first.lock(false)
+	if err != nil {
+		t.Fatalf("lock: %v", err)
+	}
+	// Другой экземпляр журнала, как в параллельно запущенной команде recover
+	second, err := openCoordinatorLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := second.lock(false); err == nil {
+		t.Fatal("second lock succeeded while the log is locked")
+	} else if want := "процессом " + strconv.Itoa(os.Getpid()); !strings.Contains(err.Error(), want) {
+		t.Errorf("lock error %q does not name the owner %q", err, want)
+	}
+
+	unlock()
+	unlock, err = second.lock(false)
+	if err != nil {
+		t.Fatalf("lock after unlock: %v", err)
+	}
+	unlock()
+}
+
+func TestOutcomeAbandoned(t *testing.T) {
+	coord, err := openCoordinatorLog(filepath.Join(t.TempDir(), defaultCoordinatorLogName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	runID := newRunID()
+	gid := newGID(runID, "a")
+	if err := coord.append(runID, coordPrepared, []participantGID{{Cluster: "a", GID: gid}}); err != nil {
+		t.Fatal(err)
+	}
+	outcomes, err := coord.outcomes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	outcome := outcomes[gid]
+	if outcome.PID != os.Getpid() || outcome.Host != localHost() || outcome.Prepared.IsZero() {
+		t.Fatalf("outcome = %+v, want owner and time of the prepared record", outcome)
+	}
+
+	now := outcome.Prepared.Add(time.Minute)
+	// Процесс передачи жив: транзакция без решения не трогается, пока запись моложе minAge
+	if outcome.abandoned(now, 10*time.Minute) {
+		t.Error("running transfer considered abandoned")
+	}
+	if !outcome.abandoned(now, 30*time.Second) {
+		t.Error("old prepared record not considered abandoned")
+	}
+	// Процесс другого компьютера проверить нельзя
+	remote := outcome
+	remote.Host = localHost() + ".remote"
+	remote.PID = 1 << 30
+	if remote.abandoned(now, 10*time.Minute) {
+		t.Error("young transfer on another host considered abandoned")
+	}
+	// Процесс на этом компьютере завершился
+	dead := outcome
+	dead.PID = 1 << 30
+	if !dead.abandoned(now, 10*time.Minute) {
+		t.Error("transfer of a dead process not considered abandoned")
+	}
+}
@@ -0,0 +1,383 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/lib/pq"
+	"strings"
+	"time"
+)
+
+// Способы записи строк на приёмнике
+const (
+	transferMethodCopy   = "copy"   // COPY ... FROM STDIN, по одной команде COPY на пакет
+	transferMethodInsert = "insert" // INSERT на каждую строку
+)
+
+// Размер пакета по умолчанию: строк, читаемых с источника одной командой FETCH
+const defaultBatchSize = 10000
+
+// Имя курсора, через который читаются строки таблицы на источнике
+const transferCursor = "dba_ali_transfer"
+
+// Статистика передачи таблицы
+type tableStats struct {
+	Rows        int64
+	Bytes       int64 // Объём данных в текстовом представлении
+	Elapsed     time.Duration
+	Skipped     int64 // Строки, пропущенные из-за конфликта ключа
+	Overwritten int64 // Строки приёмника, заменённые строками источника
+	Diverted    int64 // Строки, записанные в таблицу конфликтов
+
+	skippedRows []string // ctid пропущенных строк источника, которые не удаляются
+}
+
+// Добавление статистики другой таблицы
+func (s *tableStats) add(other tableStats) {
+	s.Rows += other.Rows
+	s.Bytes += other.Bytes
+	s.Elapsed += other.Elapsed
+	s.Skipped += other.Skipped
+	s.Overwritten += other.Overwritten
+	s.Diverted += other.Diverted
+}
+
+// Описание статистики для вывода: объём, время и пропускная способность
+func (s tableStats) String() string {
+	seconds := s.Elapsed.Seconds()
+	if seconds <= 0 {
+		seconds = 1e-9
+	}
+	megabytes := float64(s.Bytes) / (1 << 20)
+	result := fmt.Sprintf("строк %d, %.1f МБ за %s (%.0f строк/с, %.1f МБ/с)",
+		s.Rows, megabytes, s.Elapsed.Round(time.Millisecond), float64(s.Rows)/seconds, megabytes/seconds)
+	if s.Skipped+s.Overwritten+s.Diverted > 0 {
+		result += fmt.Sprintf("; конфликты: пропущено %d, перезаписано %d, в таблицу конфликтов %d",
+			s.Skipped, s.Overwritten, s.Diverted)
+	}
+	return result
+}
+
+// Способ записи пакета строк на приёмнике
+type rowWriter interface {
+	begin() error
+	write(values []any) (rowOutcome, error)
+	end() error
+	close() error
+}
+
+// Запись строк командой COPY FROM STDIN. lib/pq накапливает строки и отправляет их
+// на сервер блоками, а завершение COPY выполняется на каждом пакете
+type copyWriter struct {
+	tx     *sql.Tx
+	table  *tableInfo
+	server string // Имя приёмника для сообщений
+	stmt   *sql.Stmt
+}
+
+func (w *copyWriter) begin() error {
+	var cols []string
+	for _, c := range w.table.dataColumns() {
+		cols = append(cols, c.Name)
+	}
+	stmt, err := w.tx.Prepare(pq.CopyInSchema(w.table.Schema, w.table.Name, cols...))
+	if err != nil {
+		return fmt.Errorf("Ошибка начала COPY в %s на сервере %s: %v", w.table, w.server, err)
+	}
+	w.stmt = stmt
+	return nil
+}
+
+func (w *copyWriter) write(values []any) (rowOutcome, error) {
+	if _, err := w.stmt.Exec(values...); err != nil {
+		return rowInserted, fmt.Errorf("Ошибка COPY в %s на сервере %s: %v", w.table, w.server, conflictError(w.table, err))
+	}
+	return rowInserted, nil
+}
+
+func (w *copyWriter) end() error {
+	_, err := w.stmt.Exec()
+	closeErr := w.stmt.Close()
+	w.stmt = nil
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("Ошибка завершения COPY в %s на сервере %s: %v", w.table, w.server, conflictError(w.table, err))
+	}
+	return nil
+}
+
+func (w *copyWriter) close() error {
+	if w.stmt != nil {
+		return w.stmt.Close()
+	}
+	return nil
+}
+
+// Запись строк подготовленной командой INSERT по одной строке
+type insertWriter struct {
+	tx     *sql.Tx
+	table  *tableInfo
+	server string // Имя приёмника для сообщений
+	stmt   *sql.Stmt
+}
+
+// Параметры значений столбцов: значения передаются текстом и приводятся к типу столбца
+func valuePlaceholders(cols []columnInfo) []string {
+	placeholders := make([]string, len(cols))
+	for i, c := range cols {
+		placeholders[i] = fmt.Sprintf("$%d::%s", i+1, c.Type)
+	}
+	return placeholders
+}
+
+// Подготовка вставки строки в таблицу t на приёмнике server. suffix дописывается
+// после VALUES, например ON CONFLICT DO NOTHING
+func prepareInsert(tx *sql.Tx, t *tableInfo, server, suffix string) (*sql.Stmt, error) {
+	cols := t.dataColumns()
+	overriding := ""
+	for _, c := range cols {
+		if c.Identity == "ALWAYS" {
+			overriding = " OVERRIDING SYSTEM VALUE"
+		}
+	}
+	stmt, err := tx.Prepare(fmt.Sprintf("INSERT INTO %s (%s)%s VALUES (%s)%s",
+		t.qualified(), columnList(cols, nil), overriding, strings.Join(valuePlaceholders(cols), ", "), suffix))
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка подготовки вставки в %s на сервере %s: %v", t, server, err)
+	}
+	return stmt, nil
+}
+
+func (w *insertWriter) begin() error {
+	if w.stmt != nil {
+		return nil
+	}
+	stmt, err := prepareInsert(w.tx, w.table, w.server, "")
+	if err != nil {
+		return err
+	}
+	w.stmt = stmt
+	return nil
+}
+
+func (w *insertWriter) write(values []any) (rowOutcome, error) {
+	if _, err := w.stmt.Exec(values...); err != nil {
+		return rowInserted, fmt.Errorf("Ошибка вставки данных в %s на сервере %s: %v", w.table, w.server, conflictError(w.table, err))
+	}
+	return rowInserted, nil
+}
+
+func (w *insertWriter) end() error {
+	return nil
+}
+
+func (w *insertWriter) close() error {
+	if w.stmt != nil {
+		return w.stmt.Close()
+	}
+	return nil
+}
+
+// Способ записи строк для параметров передачи. Политики конфликтов, кроме fail,
+// требуют INSERT ... ON CONFLICT
+func newRowWriter(tx *sql.Tx, t *tableInfo, opts transferOptions) (rowWriter, error) {
+	if opts.onConflict() != conflictFail {
+		return &conflictWriter{tx: tx, table: t, server: opts.destination, policy: opts.onConflict(), runID: opts.runID}, nil
+	}
+	switch opts.method() {
+	case transferMethodCopy:
+		return &copyWriter{tx: tx, table: t, server: opts.destination}, nil
+	case transferMethodInsert:
+		return &insertWriter{tx: tx, table: t, server: opts.destination}, nil
+	}
+	return nil, fmt.Errorf("Неизвестный способ передачи %q (допустимо: %s, %s)", opts.Method, transferMethodCopy, transferMethodInsert)
+}
+
+// Передача строк одной таблицы. Строки читаются на источнике курсором пакетами по
+// opts.BatchSize в текстовом представлении и записываются на приёмнике, где приводятся
+// к типу столбца, что сохраняет любые типы и NULL. chunk - порция таблицы (nil - вся таблица)
+func copyTableRows(txA, txB *sql.Tx, t *tableInfo, opts transferOptions, chunk *tableChunk) (tableStats, error) {
+	var stats tableStats
+	start := time.Now()
+
+	writer, err := newRowWriter(txB, t, opts)
+	if err != nil {
+		return stats, err
+	}
+	defer writer.close()
+
+	filter, err := opts.tableFilter(t)
+	if err != nil {
+		return stats, err
+	}
+	filter = chunk.restrict(filter, false)
+	cols := t.dataColumns()
+	keyIndex := -1
+	for i, c := range cols {
+		if chunk != nil && c.Name == chunk.Key.Name {
+			keyIndex = i
+		}
+	}
+	selectList := columnList(cols, func(c columnInfo) string { return "::text" })
+	// При пропуске конфликтующих строк их ctid нужен, чтобы не удалить их на источнике.
+	// При overwrite пропускаются строки из одних ключевых столбцов, уже записанные на приёмнике
+	policy := opts.onConflict()
+	withCtid := (policy == conflictSkip || policy == conflictOverwrite) && opts.deletesSource()
+	if withCtid {
+		selectList += ", ctid::text"
+	}
+	fmt.Printf("Выполняю команду DECLARE CURSOR FOR SELECT ... FROM %s%s%s на сервере %s\n", t, whereClause(filter), chunk.orderLimit(), opts.source)
+	if _, err := txA.Exec(fmt.Sprintf("DECLARE %s NO SCROLL CURSOR FOR SELECT %s FROM %s%s%s",
+		transferCursor, selectList, t.qualified(), whereClause(filter), chunk.orderLimit())); err != nil {
+		return stats, fmt.Errorf("Ошибка выборки данных из %s на сервере %s: %v", t, opts.source, err)
+	}
+
+	values := make([]sql.NullString, len(cols))
+	dest := make([]any, len(cols))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	var ctid string
+	if withCtid {
+		dest = append(dest, &ctid)
+	}
+	fetch := fmt.Sprintf("FETCH %d FROM %s", opts.batchSize(), transferCursor)
+	for {
+		batch, err := copyBatch(txA, fetch, writer, values, dest, &ctid, &stats)
+		if err != nil {
+			return stats, fmt.Errorf("Ошибка передачи таблицы %s: %v", t, err)
+		}
+		if batch == 0 {
+			break
+		}
+		if keyIndex >= 0 {
+			chunk.Last = values[keyIndex].String
+		}
+		fmt.Printf("\rПередано строк таблицы %s: %d", t, stats.Rows)
+	}
+	if stats.Rows > 0 {
+		fmt.Println()
+	}
+	if _, err := txA.Exec("CLOSE " + transferCursor); err != nil {
+		return stats, fmt.Errorf("Ошибка закрытия курсора на сервере %s: %v", opts.source, err)
+	}
+	stats.Elapsed = time.Since(start)
+	return stats, nil
+}
+
+// Передача одного пакета строк. Возвращает число строк в пакете
+func copyBatch(txA *sql.Tx, fetch string, writer rowWriter, values []sql.NullString, dest []any, ctid *string, stats *tableStats) (int, error) {
+	rows, err := txA.Query(fetch)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	count := 0
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return count, err
+		}
+		if count == 0 {
+			if err := writer.begin(); err != nil {
+				return count, err
+			}
+		}
+		params := make([]any, len(values))
+		for i, v := range values {
+			if v.Valid {
+				params[i] = v.String
+				stats.Bytes += int64(len(v.String))
+			}
+		}
+		outcome, err := writer.write(params)
+		if err != nil {
+			return count, err
+		}
+		switch outcome {
+		case rowSkipped:
+			stats.Skipped++
+			stats.skippedRows = append(stats.skippedRows, *ctid)
+		case rowOverwritten:
+			stats.Overwritten++
+		case rowDiverted:
+			stats.Diverted++
+		}
+		count++
+		stats.Rows++
+	}
+	if err := rows.Err(); err != nil {
+		return count, err
+	}
+	if count > 0 {
+		return count, writer.end()
+	}
+	return 0, nil
+}
+
+// Удаление переданных строк на источнике по тому же условию отбора. Транзакция источника
+// работает со снимком REPEATABLE READ, поэтому удаляются ровно те строки, которые были прочитаны.
+// Строки, пропущенные из-за конфликта, остаются на источнике
+func deleteTableRows(txA *sql.Tx, t *tableInfo, opts transferOptions, chunk *tableChunk, stats tableStats) error {
+	filter, err := opts.tableFilter(t)
+	if err != nil {
+		return err
+	}
+	filter = chunk.restrict(filter, true)
+	var args []any
+	if len(stats.skippedRows) > 0 {
+		if filter != "" {
+			filter += " AND "
+		}
+		filter += "ctid <> ALL($1::tid[])"
+		args = append(args, pq.Array(stats.skippedRows))
+	}
+	expected := stats.Rows - stats.Skipped
+	fmt.Printf("Выполняю команду DELETE FROM %s%s на сервере %s\n", t, whereClause(filter), opts.source)
+	res, err := txA.Exec("DELETE FROM "+t.qualified()+whereClause(filter), args...)
+	if err != nil {
+		return fmt.Errorf("Ошибка удаления данных из %s на сервере %s: %v", t, opts.source, err)
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted != expected {
+		return fmt.Errorf("Из %s на сервере %s удалено %d строк вместо переданных %d", t, opts.source, deleted, expected)
+	}
+	return nil
+}
+
+// Перенос строк всех таблиц: вставка на приёмнике в порядке от родительских таблиц
+// к дочерним, сверка (с opts.Verify), удаление на источнике (кроме режима copy) в обратном порядке.
+// Возвращает статистику по таблицам. chunk - порция единственной таблицы (nil - все строки)
+func moveTables(txA, txB *sql.Tx, tables []*tableInfo, opts transferOptions, chunk *tableChunk) (map[string]tableStats, error) {
+	stats := make(map[string]tableStats)
+	for _, t := range tables {
+		s, err := copyTableRows(txA, txB, t, opts, chunk)
+		if err != nil {
+			return nil, err
+		}
+		stats[t.String()] = s
+	}
+	if opts.Verify {
+		if err := verifyTransferred(txA, txB, tables, opts, chunk, stats); err != nil {
+			return nil, err
+		}
+	}
+	if !opts.deletesSource() {
+		return stats, nil
+	}
+	for i := len(tables) - 1; i >= 0; i-- {
+		t := tables[i]
+		if stats[t.String()].Rows == 0 {
+			continue
+		}
+		if err := deleteTableRows(txA, t, opts, chunk, stats[t.String()]); err != nil {
+			return nil, err
+		}
+	}
+	return stats, nil
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestConninfoRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		params connParams
+		want   map[string]string
+	}{
+		{
+			name:   "defaults",
+			params: connParams{SSLMode: sslDisable},
+			want:   map[string]string{"user": "postgres", "host": "localhost", "port": "5432", "sslmode": "disable"},
+		},
+		{
+			name:   "socket directory",
+			params: connParams{User: "dba", Host: "/var/run/postgresql", Port: "5433", SSLMode: sslDisable},
+			want:   map[string]string{"user": "dba", "host": "/var/run/postgresql", "port": "5433", "sslmode": "disable"},
+		},
+		{
+			name:   "password with spaces and quotes",
+			params: connParams{Password: `it's a \secret`, SSLMode: sslDisable},
+			want:   map[string]string{"user": "postgres", "host": "localhost", "port": "5432", "sslmode": "disable", "password": `it's a \secret`},
+		},
+		{
+			name:   "certificates",
+			params: connParams{SSLMode: sslVerifyFull, SSLRootCert: "/tls/ca.crt", SSLCert: "/tls/my client.crt", SSLKey: "/tls/client.key"},
+			want: map[string]string{"user": "postgres", "host": "localhost", "port": "5432", "sslmode": "verify-full",
+				"sslrootcert": "/tls/ca.crt", "sslcert": "/tls/my client.crt", "sslkey": "/tls/client.key"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseConninfo(tt.params.conninfo())
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseConninfo(%q) = %v, want %v", tt.params.conninfo(), got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConninfoValue(t *testing.T) {
+	tests := []struct {
+		value, want string
+	}{
+		{"postgres", "postgres"},
+		{"", "''"},
+		{"two words", "'two words'"},
+		{"it's", `'it\'s'`},
+		{`back\slash`, `'back\\slash'`},
+	}
+	for _, tt := range tests {
+		if got := conninfoValue(tt.value); got != tt.want {
+			t.Errorf("conninfoValue(%q) = %s, want %s", tt.value, got, tt.want)
+		}
+		if got := parseConninfo("key=" + conninfoValue(tt.value))["key"]; got != tt.value {
+			t.Errorf("round trip of %q = %q", tt.value, got)
+		}
+	}
+}
+
+func TestRedactSecrets(t *testing.T) {
+	registerSecret("s3cr3t-value")
+	registerSecret("abc") // Слишком короткий пароль не скрывается
+	tests := []struct {
+		name, in, want string
+	}{
+		{"conninfo", "user=postgres password=hunter22 host=db", "user=postgres password=*** host=db"},
+		{"conninfo quoted", `password='a b\'c' host=db`, "password=*** host=db"},
+		{"conninfo spaces and case", "PASSWORD = hunter22", "PASSWORD = ***"},
+		{"url", "postgres://dba:hunter22@db:5432/app", "postgres://dba:***@db:5432/app"},
+		{"postgresql url", "connect postgresql://dba:p%40ss@db/app failed", "connect postgresql://dba:***@db/app failed"},
+		{"url without password", "postgres://dba@db/app", "postgres://dba@db/app"},
+		{"registered secret", "pq: password s3cr3t-value rejected", "pq: password *** rejected"},
+		{"short secret", "abc def", "abc def"},
+		{"no secrets", "Ошибка подключения к серверу A", "Ошибка подключения к серверу A"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := redactSecrets(tt.in); got != tt.want {
+				t.Errorf("redactSecrets(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPgpassFields(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{"db:5432:app:dba:secret", []string{"db", "5432", "app", "dba", "secret"}},
+		{"*:*:*:*:secret", []string{"*", "*", "*", "*", "secret"}},
+		{`db:5432:app:dba:pa\:ss`, []string{"db", "5432", "app", "dba", "pa:ss"}},
+		{`db:5432:app:dba:pa\\ss`, []string{"db", "5432", "app", "dba", `pa\ss`}},
+		{`db:5432:app:dba:pa\\:ss`, []string{"db", "5432", "app", "dba", `pa\`, "ss"}},
+		{`db\:1:5432:app:dba:`, []string{"db:1", "5432", "app", "dba", ""}},
+		{"db:5432", []string{"db", "5432"}},
+	}
+	for _, tt := range tests {
+		if got := pgpassFields(tt.line); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("pgpassFields(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestLookupPgpass(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".pgpass")
+	content := strings.Join([]string{
+		"# комментарий",
+		"db:5432:app:dba:app-secret",
+		"db:5432:*:dba:any-db",
+		`localhost:5433:*:postgres:local\:secret`,
+		"broken:line",
+		"*:*:*:*:fallback",
+	}, "\n")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name                     string
+		host, port, dbname, user string
+		want                     string
+	}{
+		{"exact match", "db", "5432", "app", "dba", "app-secret"},
+		{"first matching line wins", "db", "5432", "other", "dba", "any-db"},
+		{"socket as localhost with escape", "/var/run/postgresql", "5433", "app", "postgres", "local:secret"},
+		{"wildcard", "other", "5432", "app", "dba", "fallback"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, found := lookupPgpass(path, tt.host, tt.port, tt.dbname, tt.user)
+			if !found || got != tt.want {
+				t.Errorf("lookupPgpass = %q, %v, want %q", got, found, tt.want)
+			}
+		})
+	}
+
+	if _, found := lookupPgpass(filepath.Join(t.TempDir(), "missing"), "db", "5432", "app", "dba"); found {
+		t.Error("lookupPgpass found a password in a missing file")
+	}
+	if runtime.GOOS != "windows" {
+		if err := os.Chmod(path, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, found := lookupPgpass(path, "db", "5432", "app", "dba"); found {
+			t.Error("lookupPgpass used a file readable by others")
+		}
+	}
+}
+
+func TestReadPasswordFromPipe(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdin := os.Stdin
+	os.Stdin = r
+	defer func() { os.Stdin = stdin }()
+	if _, err := w.WriteString("pass with spaces\r\nnext answer\n"); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	if got := readPassword(); got != "pass with spaces" {
+		t.Errorf("readPassword() = %q, want %q", got, "pass with spaces")
+	}
+	// Следующая строка остаётся для остальных запросов
+	if got := readPassword(); got != "next answer" {
+		t.Errorf("second readPassword() = %q, want %q", got, "next answer")
+	}
+}
@@ -0,0 +1,50 @@
+//go:build !windows
+
+package main
+
+import (
+	"bytes"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/ianaindex"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+	"io"
+	"os"
+	"strings"
+)
+
+// Определение кодировки из локали (LC_ALL, LC_CTYPE, LANG), например ru_RU.KOI8-R.
+// Возвращает nil для UTF-8 и когда кодировку определить не удалось
+func localeEncoding() encoding.Encoding {
+	for _, env := range []string{"LC_ALL", "LC_CTYPE", "LANG"} {
+		locale := os.Getenv(env)
+		if locale == "" {
+			continue
+		}
+		_, charset, found := strings.Cut(locale, ".")
+		if !found {
+			return nil
+		}
+		charset, _, _ = strings.Cut(charset, "@")
+		enc, err := ianaindex.IANA.Encoding(charset)
+		if err != nil || enc == nil || enc == unicode.UTF8 {
+			return nil
+		}
+		return enc
+	}
+	return nil
+}
+
+// Функция для декодирования стандартного вывода утилит PostgreSQL в кодировке локали
+func decodeOutput(output []byte) (string, error) {
+	enc := localeEncoding()
+	if enc == nil {
+		return string(output), nil
+	}
+	reader := transform.NewReader(bytes.NewReader(output), enc.NewDecoder())
+	decodeBytes, err := io.ReadAll(reader)
+	if err != nil {
+		return "", err
+	}
+	return string(decodeBytes), nil
+}
@@ -0,0 +1,49 @@
+//go:build windows
+
+package main
+
+import (
+	"bytes"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/transform"
+	"io"
+	"syscall"
+)
+
+// Кодировки консоли Windows по номеру кодовой страницы
+var consoleCodePages = map[uint32]encoding.Encoding{
+	866:  charmap.CodePage866,
+	1251: charmap.Windows1251,
+	1252: charmap.Windows1252,
+}
+
+// Определение кодировки вывода консоли. По умолчанию CP1251, как у русской Windows
+func consoleEncoding() encoding.Encoding {
+	proc := syscall.NewLazyDLL("kernel32.dll").NewProc("GetConsoleOutputCP")
+	if proc.Find() != nil {
+		return charmap.Windows1251
+	}
+	cp, _, _ := proc.Call()
+	if cp == 65001 {
+		return nil // UTF-8, декодирование не требуется
+	}
+	if enc, ok := consoleCodePages[uint32(cp)]; ok {
+		return enc
+	}
+	return charmap.Windows1251
+}
+
+// Функция для декодирования стандартного вывода утилит PostgreSQL в кодировке консоли
+func decodeOutput(output []byte) (string, error) {
+	enc := consoleEncoding()
+	if enc == nil {
+		return string(output), nil
+	}
+	reader := transform.NewReader(bytes.NewReader(output), enc.NewDecoder())
+	decodeBytes, err := io.ReadAll(reader)
+	if err != nil {
+		return "", err
+	}
+	return string(decodeBytes), nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Этап передачи данных, на котором произошла ошибка
+type transferPhase string
+
+const (
+	phasePreflight   transferPhase = "проверка серверов"
+	phaseConnect     transferPhase = "подключение"
+	phaseBegin       transferPhase = "начало транзакции"
+	phaseTables      transferPhase = "подготовка таблиц"
+	phaseCopy        transferPhase = "передача строк"
+	phaseVerify      transferPhase = "сверка"
+	phaseSequences   transferPhase = "синхронизация последовательностей"
+	phaseCoordinator transferPhase = "запись в журнал координатора"
+	phasePrepare     transferPhase = "подготовка транзакции"
+	phaseCrash       transferPhase = "симуляция падения"
+	phaseCommit      transferPhase = "фиксация"
+)
+
+// Ошибка передачи данных с этапом и сервером. Транзакции к моменту возврата ошибки
+// откачены, а если это невозможно - решение записано в журнал координатора (InDoubt)
+type transferError struct {
+	Phase   transferPhase
+	Server  string // Имя кластера. Пусто, если ошибка не относится к одному серверу
+	RunID   string
+	InDoubt bool // Подготовленные транзакции остались на серверах, их завершит команда recover
+	Err     error
+}
+
+func (e *transferError) Error() string {
+	msg := "Ошибка передачи данных (" + string(e.Phase)
+	if e.Server != "" {
+		msg += ", сервер " + e.Server
+	}
+	if e.RunID != "" {
+		msg += ", запуск " + e.RunID
+	}
+	msg += fmt.Sprintf("): %v", e.Err)
+	if e.InDoubt {
+		msg += ". Подготовленные транзакции не завершены, выполните команду recover"
+	}
+	return msg
+}
+
+func (e *transferError) Unwrap() error {
+	return e.Err
+}
+
+// Ошибка этапа phase на сервере server. Ошибка, уже содержащая этап, не оборачивается повторно
+func newTransferError(phase transferPhase, server string, err error) error {
+	var te *transferError
+	if errors.As(err, &te) {
+		return err
+	}
+	return &transferError{Phase: phase, Server: server, Err: err}
+}
+
+// Заполнение идентификатора запуска в ошибке передачи
+func withRunID(err error, runID string) error {
+	var te *transferError
+	if errors.As(err, &te) && te.RunID == "" {
+		te.RunID = runID
+	}
+	return err
+}
+
+// Этап, на котором произошла ошибка передачи. Пусто, если это не ошибка передачи
+func failedPhase(err error) transferPhase {
+	var te *transferError
+	if errors.As(err, &te) {
+		return te.Phase
+	}
+	return ""
+}
+
+// Остались ли после ошибки передачи незавершённые подготовленные транзакции
+func inDoubt(err error) bool {
+	var te *transferError
+	return errors.As(err, &te) && te.InDoubt
+}
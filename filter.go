@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"github.com/lib/pq"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Режимы передачи данных
+const (
+	transferModeMove    = "move"    // Перенос: строки удаляются на источнике
+	transferModeCopy    = "copy"    // Копирование: источник не изменяется
+	transferModeArchive = "archive" // Архивирование: перенос строк старше заданного момента
+)
+
+// Режим передачи
+func (o transferOptions) mode() string {
+	if o.Mode != "" {
+		return o.Mode
+	}
+	return transferModeMove
+}
+
+// Удаляются ли переданные строки на источнике
+func (o transferOptions) deletesSource() bool {
+	return o.mode() != transferModeCopy
+}
+
+// Разбор границы архивирования: момент времени (2006-01-02, 2006-01-02 15:04:05, RFC 3339)
+// или возраст относительно now (90d, 12h, 30m)
+func parseOlderThan(value string, now time.Time) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if days, found := strings.CutSuffix(value, "d"); found {
+		if n, err := strconv.Atoi(days); err == nil && n >= 0 {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("Неверная граница архивирования %q: ожидается дата, дата и время или возраст вида 90d, 12h", value)
+}
+
+// Столбец таблицы по имени
+func (t *tableInfo) column(name string) (columnInfo, bool) {
+	for _, c := range t.Columns {
+		if c.Name == name {
+			return c, true
+		}
+	}
+	return columnInfo{}, false
+}
+
+// Столбец для диапазона ключей: заданный явно или первичный ключ из одного столбца
+func (o transferOptions) keyColumn(t *tableInfo) (columnInfo, error) {
+	name := o.KeyColumn
+	if name == "" {
+		if len(t.PrimaryKey) != 1 {
+			return columnInfo{}, fmt.Errorf("У таблицы %s нет первичного ключа из одного столбца, укажите столбец ключа", t)
+		}
+		name = t.PrimaryKey[0]
+	}
+	c, ok := t.column(name)
+	if !ok {
+		return columnInfo{}, fmt.Errorf("В таблице %s нет столбца ключа %s", t, name)
+	}
+	return c, nil
+}
+
+// Условие отбора строк таблицы на источнике. Пустая строка - передаются все строки.
+// Значения границ подставляются литералами с приведением к типу столбца
+func (o transferOptions) tableFilter(t *tableInfo) (string, error) {
+	var conds []string
+	if o.Where != "" {
+		conds = append(conds, "("+o.Where+")")
+	}
+	if o.KeyFrom != "" || o.KeyTo != "" {
+		key, err := o.keyColumn(t)
+		if err != nil {
+			return "", err
+		}
+		if o.KeyFrom != "" {
+			conds = append(conds, fmt.Sprintf("%s >= %s::%s", pq.QuoteIdentifier(key.Name), pq.QuoteLiteral(o.KeyFrom), key.Type))
+		}
+		if o.KeyTo != "" {
+			conds = append(conds, fmt.Sprintf("%s < %s::%s", pq.QuoteIdentifier(key.Name), pq.QuoteLiteral(o.KeyTo), key.Type))
+		}
+	}
+	if o.mode() == transferModeArchive {
+		c, ok := t.column(o.TimeColumn)
+		if !ok {
+			return "", fmt.Errorf("В таблице %s нет столбца времени %s", t, o.TimeColumn)
+		}
+		conds = append(conds, fmt.Sprintf("%s < %s::timestamptz", pq.QuoteIdentifier(c.Name), pq.QuoteLiteral(o.cutoff.Format(time.RFC3339Nano))))
+	}
+	return strings.Join(conds, " AND "), nil
+}
+
+// Часть запроса WHERE для условия отбора
+func whereClause(filter string) string {
+	if filter == "" {
+		return ""
+	}
+	return " WHERE " + filter
+}
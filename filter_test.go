@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseOlderThan(t *testing.T) {
+	now := time.Date(2024, 10, 18, 15, 30, 0, 0, time.Local)
+	tests := []struct {
+		value string
+		want  time.Time
+		ok    bool
+	}{
+		{"90d", now.AddDate(0, 0, -90), true},
+		{"0d", now, true},
+		{" 12h ", now.Add(-12 * time.Hour), true},
+		{"30m", now.Add(-30 * time.Minute), true},
+		{"1h30m", now.Add(-90 * time.Minute), true},
+		{"2024-01-02", time.Date(2024, 1, 2, 0, 0, 0, 0, time.Local), true},
+		{"2024-01-02 03:04:05", time.Date(2024, 1, 2, 3, 4, 5, 0, time.Local), true},
+		{"2024-01-02T03:04:05Z", time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), true},
+		{"-5d", time.Time{}, false},
+		{"-1h", time.Time{}, false},
+		{"d", time.Time{}, false},
+		{"yesterday", time.Time{}, false},
+		{"", time.Time{}, false},
+	}
+	for _, tt := range tests {
+		got, err := parseOlderThan(tt.value, now)
+		if (err == nil) != tt.ok || !got.Equal(tt.want) {
+			t.Errorf("parseOlderThan(%q) = %v, %v, want %v", tt.value, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,463 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/lib/pq"
+	"gopkg.in/yaml.v3"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Генераторы значений столбцов
+const (
+	generatorSequence  = "sequence"  // Возрастающая последовательность: start, step, format
+	generatorInt       = "int"       // Целое число из диапазона min..max по распределению
+	generatorFloat     = "float"     // Дробное число из диапазона min..max по распределению
+	generatorString    = "string"    // Случайная строка: prefix, min_length, max_length, charset
+	generatorTimestamp = "timestamp" // Время из диапазона from..to по распределению
+	generatorChoice    = "choice"    // Одно из значений values с весами weights
+)
+
+// Распределения чисел и времени
+const (
+	distributionUniform     = "uniform"     // Равномерное (по умолчанию)
+	distributionNormal      = "normal"      // Нормальное: mean, stddev (по умолчанию середина и шестая часть диапазона)
+	distributionExponential = "exponential" // Экспоненциальное от min: mean (по умолчанию четверть диапазона)
+	distributionZipf        = "zipf"        // Ципфа от min для int: s > 1 (по умолчанию 1.1), малые значения встречаются чаще
+)
+
+// Символы случайных строк по умолчанию
+const defaultCharset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// Число строк тестовой таблицы Data по умолчанию
+const defaultFixtureRows = 9
+
+// Описание генерации данных: начальное значение генератора случайных чисел и таблицы.
+// Задаётся файлом YAML (см. generate.example.yaml)
+type generateSpec struct {
+	Seed   int64       `yaml:"seed"` // 0 - выбирается по времени и выводится для повторения
+	Tables []tableSpec `yaml:"tables"`
+}
+
+// Генерация строк одной таблицы. Столбцы, не указанные в columns, получают значения по умолчанию
+type tableSpec struct {
+	Table     string       `yaml:"table"` // Таблица вида schema.table
+	Rows      int          `yaml:"rows"`
+	BatchSize int          `yaml:"batch_size"` // Строк в команде COPY (по умолчанию 10000)
+	Columns   []columnSpec `yaml:"columns"`
+}
+
+// Генератор значений столбца
+type columnSpec struct {
+	Name         string    `yaml:"name"`
+	Generator    string    `yaml:"generator"`
+	NullRatio    float64   `yaml:"null_ratio"` // Доля NULL от 0 до 1
+	Start        *int64    `yaml:"start"`      // sequence: первое значение (по умолчанию 1)
+	Step         int64     `yaml:"step"`       // sequence: шаг (по умолчанию 1)
+	Format       string    `yaml:"format"`     // sequence, int, float: формат fmt, например "Value %d"
+	Min          float64   `yaml:"min"`
+	Max          float64   `yaml:"max"`
+	Distribution string    `yaml:"distribution"`
+	Mean         float64   `yaml:"mean"`
+	StdDev       float64   `yaml:"stddev"`
+	S            float64   `yaml:"s"`
+	From         string    `yaml:"from"` // timestamp: дата или возраст (365d, 12h)
+	To           string    `yaml:"to"`   // timestamp: дата или возраст (по умолчанию сейчас)
+	Prefix       string    `yaml:"prefix"`
+	MinLength    int       `yaml:"min_length"` // string: по умолчанию 8
+	MaxLength    int       `yaml:"max_length"` // string: по умолчанию min_length
+	Charset      string    `yaml:"charset"`
+	Values       []string  `yaml:"values"`
+	Weights      []float64 `yaml:"weights"`
+}
+
+// Описание генерации тестовой таблицы Data: строки "Value 1", "Value 2", ...
+func fixtureSpec(rows int) *generateSpec {
+	if rows <= 0 {
+		rows = defaultFixtureRows
+	}
+	return &generateSpec{Tables: []tableSpec{{
+		Table:   defaultTransferTable,
+		Rows:    rows,
+		Columns: []columnSpec{{Name: "value", Generator: generatorSequence, Format: "Value %d"}},
+	}}}
+}
+
+// Чтение описания генерации из файла YAML
+func loadGenerateSpec(path string) (*generateSpec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка чтения описания генерации %s: %v", path, err)
+	}
+	spec := &generateSpec{}
+	if err := yaml.Unmarshal(data, spec); err != nil {
+		return nil, fmt.Errorf("Ошибка разбора описания генерации %s: %v", path, err)
+	}
+	if len(spec.Tables) == 0 {
+		return nil, fmt.Errorf("В описании генерации %s нет таблиц", path)
+	}
+	return spec, nil
+}
+
+// Замена числа строк всех таблиц описания
+func (s *generateSpec) withRows(rows int) *generateSpec {
+	if rows <= 0 {
+		return s
+	}
+	result := *s
+	result.Tables = make([]tableSpec, len(s.Tables))
+	for i, t := range s.Tables {
+		t.Rows = rows
+		result.Tables[i] = t
+	}
+	return &result
+}
+
+// Начальное значение генератора случайных чисел. Если не задано, выбирается по времени
+// и выводится, чтобы генерацию можно было повторить
+func (s *generateSpec) seed() int64 {
+	if s.Seed == 0 {
+		s.Seed = time.Now().UnixNano()
+		fmt.Printf("Начальное значение генератора (seed): %d\n", s.Seed)
+	}
+	return s.Seed
+}
+
+// Генератор значений столбца, проверенный по описанию таблицы
+type columnGenerator struct {
+	spec     columnSpec
+	column   columnInfo
+	next     int64   // Следующее значение последовательности
+	min, max float64 // Диапазон чисел, для времени - в наносекундах Unix
+	zipf     *rand.Zipf
+	weights  []float64 // Накопленные веса значений choice
+}
+
+// Проверка генератора столбца и вычисление его параметров
+func newColumnGenerator(spec columnSpec, column columnInfo, r *rand.Rand, now time.Time) (*columnGenerator, error) {
+	g := &columnGenerator{spec: spec, column: column, min: spec.Min, max: spec.Max}
+	if spec.NullRatio < 0 || spec.NullRatio > 1 {
+		return nil, fmt.Errorf("Доля NULL столбца %s должна быть от 0 до 1", spec.Name)
+	}
+	if spec.NullRatio > 0 && column.NotNull {
+		return nil, fmt.Errorf("Столбец %s объявлен NOT NULL, доля NULL невозможна", spec.Name)
+	}
+	if column.Generated != "" {
+		return nil, fmt.Errorf("Столбец %s вычисляемый, его значения не генерируются", spec.Name)
+	}
+
+	switch spec.Generator {
+	case generatorSequence:
+		g.next = 1
+		if spec.Start != nil {
+			g.next = *spec.Start
+		}
+		if g.spec.Step == 0 {
+			g.spec.Step = 1
+		}
+		return g, nil
+	case generatorInt, generatorFloat:
+		if spec.Max <= spec.Min {
+			return nil, fmt.Errorf("Для генератора %s столбца %s укажите min и max (max больше min)", spec.Generator, spec.Name)
+		}
+	case generatorTimestamp:
+		if spec.From == "" {
+			return nil, fmt.Errorf("Для генератора %s столбца %s укажите from", spec.Generator, spec.Name)
+		}
+		from, err := parseOlderThan(spec.From, now)
+		if err != nil {
+			return nil, fmt.Errorf("Столбец %s: неверное значение from %q", spec.Name, spec.From)
+		}
+		to := now
+		if spec.To != "" {
+			if to, err = parseOlderThan(spec.To, now); err != nil {
+				return nil, fmt.Errorf("Столбец %s: неверное значение to %q", spec.Name, spec.To)
+			}
+		}
+		if !to.After(from) {
+			return nil, fmt.Errorf("Столбец %s: граница to должна быть позже from", spec.Name)
+		}
+		g.min, g.max = float64(from.UnixNano()), float64(to.UnixNano())
+	case generatorString:
+		if g.spec.MinLength == 0 {
+			g.spec.MinLength = 8
+		}
+		if g.spec.MaxLength == 0 {
+			g.spec.MaxLength = g.spec.MinLength
+		}
+		if g.spec.MinLength < 0 || g.spec.MaxLength < g.spec.MinLength {
+			return nil, fmt.Errorf("Столбец %s: max_length должна быть не меньше min_length", spec.Name)
+		}
+		if g.spec.Charset == "" {
+			g.spec.Charset = defaultCharset
+		}
+		return g, nil
+	case generatorChoice:
+		if len(spec.Values) == 0 {
+			return nil, fmt.Errorf("Для генератора %s столбца %s укажите values", spec.Generator, spec.Name)
+		}
+		if len(spec.Weights) > 0 && len(spec.Weights) != len(spec.Values) {
+			return nil, fmt.Errorf("Столбец %s: число weights должно совпадать с числом values", spec.Name)
+		}
+		total := 0.0
+		for i := range spec.Values {
+			w := 1.0
+			if len(spec.Weights) > 0 {
+				w = spec.Weights[i]
+			}
+			if w < 0 {
+				return nil, fmt.Errorf("Столбец %s: веса не могут быть отрицательными", spec.Name)
+			}
+			total += w
+			g.weights = append(g.weights, total)
+		}
+		if total == 0 {
+			return nil, fmt.Errorf("Столбец %s: сумма весов должна быть больше 0", spec.Name)
+		}
+		return g, nil
+	default:
+		return nil, fmt.Errorf("Неизвестный генератор %q столбца %s (допустимо: %s, %s, %s, %s, %s, %s)", spec.Generator, spec.Name,
+			generatorSequence, generatorInt, generatorFloat, generatorString, generatorTimestamp, generatorChoice)
+	}
+
+	// Параметры распределения для int, float и timestamp
+	width := g.max - g.min
+	switch spec.Distribution {
+	case "", distributionUniform:
+	case distributionNormal:
+		if g.spec.Mean == 0 {
+			g.spec.Mean = g.min + width/2
+		}
+		if g.spec.StdDev == 0 {
+			g.spec.StdDev = width / 6
+		}
+	case distributionExponential:
+		if g.spec.Mean == 0 {
+			g.spec.Mean = width / 4
+		}
+	case distributionZipf:
+		if spec.Generator != generatorInt {
+			return nil, fmt.Errorf("Столбец %s: распределение %s применимо только к генератору %s", spec.Name, distributionZipf, generatorInt)
+		}
+		if g.spec.S == 0 {
+			g.spec.S = 1.1
+		}
+		if g.spec.S <= 1 {
+			return nil, fmt.Errorf("Столбец %s: параметр s распределения %s должен быть больше 1", spec.Name, distributionZipf)
+		}
+		g.zipf = rand.NewZipf(r, g.spec.S, 1, uint64(math.Min(width, math.MaxInt64)))
+	default:
+		return nil, fmt.Errorf("Неизвестное распределение %q столбца %s (допустимо: %s, %s, %s, %s)", spec.Distribution, spec.Name,
+			distributionUniform, distributionNormal, distributionExponential, distributionZipf)
+	}
+	return g, nil
+}
+
+// Случайное число из диапазона min..max по распределению генератора
+func (g *columnGenerator) sample(r *rand.Rand) float64 {
+	var v float64
+	switch g.spec.Distribution {
+	case distributionNormal:
+		v = g.spec.Mean + r.NormFloat64()*g.spec.StdDev
+	case distributionExponential:
+		v = g.min + r.ExpFloat64()*g.spec.Mean
+	case distributionZipf:
+		v = g.min + float64(g.zipf.Uint64())
+	default:
+		v = g.min + r.Float64()*(g.max-g.min)
+	}
+	return math.Max(g.min, math.Min(g.max, v))
+}
+
+// Следующее значение столбца в текстовом представлении или nil для NULL
+func (g *columnGenerator) value(r *rand.Rand) any {
+	if g.spec.NullRatio > 0 && r.Float64() < g.spec.NullRatio {
+		if g.spec.Generator == generatorSequence {
+			g.next += g.spec.Step
+		}
+		return nil
+	}
+	switch g.spec.Generator {
+	case generatorSequence:
+		v := g.next
+		g.next += g.spec.Step
+		if g.spec.Format != "" {
+			return fmt.Sprintf(g.spec.Format, v)
+		}
+		return strconv.FormatInt(v, 10)
+	case generatorInt:
+		v := int64(math.Round(g.sample(r)))
+		if g.spec.Format != "" {
+			return fmt.Sprintf(g.spec.Format, v)
+		}
+		return strconv.FormatInt(v, 10)
+	case generatorFloat:
+		v := g.sample(r)
+		if g.spec.Format != "" {
+			return fmt.Sprintf(g.spec.Format, v)
+		}
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case generatorTimestamp:
+		return time.Unix(0, int64(g.sample(r))).Format(time.RFC3339Nano)
+	case generatorString:
+		n := g.spec.MinLength + r.Intn(g.spec.MaxLength-g.spec.MinLength+1)
+		charset := []rune(g.spec.Charset)
+		var b strings.Builder
+		b.WriteString(g.spec.Prefix)
+		for i := 0; i < n; i++ {
+			b.WriteRune(charset[r.Intn(len(charset))])
+		}
+		return b.String()
+	case generatorChoice:
+		x := r.Float64() * g.weights[len(g.weights)-1]
+		for i, w := range g.weights {
+			if x < w {
+				return g.spec.Values[i]
+			}
+		}
+		return g.spec.Values[len(g.spec.Values)-1]
+	}
+	return nil
+}
+
+// Генерация строк таблицы в одной транзакции командами COPY пакетами по batch_size строк.
+// truncate - очистить таблицу перед генерацией. server - имя сервера для сообщений
+func generateTable(db *sql.DB, server string, spec tableSpec, seed int64, truncate bool) (tableStats, error) {
+	var stats tableStats
+	start := time.Now()
+	tx, err := db.Begin()
+	if err != nil {
+		return stats, fmt.Errorf("Ошибка начала транзакции на сервере %s: %v", server, err)
+	}
+	defer tx.Rollback()
+
+	schema, name := splitTableName(spec.Table)
+	t, err := introspectTable(tx, schema, name)
+	if err != nil {
+		return stats, err
+	}
+	if t == nil {
+		return stats, fmt.Errorf("Таблицы %s нет на сервере %s", spec.Table, server)
+	}
+	if spec.Rows < 0 {
+		return stats, fmt.Errorf("Число строк таблицы %s не может быть отрицательным", t)
+	}
+
+	r := rand.New(rand.NewSource(seed))
+	now := time.Now()
+	generated := &tableInfo{Schema: t.Schema, Name: t.Name}
+	var generators []*columnGenerator
+	for _, cs := range spec.Columns {
+		column, ok := t.column(cs.Name)
+		if !ok {
+			return stats, fmt.Errorf("В таблице %s нет столбца %s", t, cs.Name)
+		}
+		g, err := newColumnGenerator(cs, column, r, now)
+		if err != nil {
+			return stats, fmt.Errorf("Таблица %s: %v", t, err)
+		}
+		generators = append(generators, g)
+		generated.Columns = append(generated.Columns, column)
+	}
+	if len(generators) == 0 {
+		return stats, fmt.Errorf("Для таблицы %s не указаны генераторы столбцов", t)
+	}
+
+	if truncate {
+		fmt.Printf("Выполняю команду TRUNCATE %s на сервере %s\n", t, server)
+		if _, err := tx.Exec("TRUNCATE " + t.qualified()); err != nil {
+			return stats, fmt.Errorf("Ошибка очистки таблицы %s на сервере %s: %v", t, server, err)
+		}
+	}
+
+	batchSize := spec.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultBatchSize
+	}
+	var cols []string
+	for _, c := range generated.Columns {
+		cols = append(cols, c.Name)
+	}
+	values := make([]any, len(generators))
+	for stats.Rows < int64(spec.Rows) {
+		stmt, err := tx.Prepare(pq.CopyInSchema(t.Schema, t.Name, cols...))
+		if err != nil {
+			return stats, fmt.Errorf("Ошибка начала COPY в %s на сервере %s: %v", t, server, err)
+		}
+		for n := 0; n < batchSize && stats.Rows < int64(spec.Rows); n++ {
+			for i, g := range generators {
+				values[i] = g.value(r)
+				if s, ok := values[i].(string); ok {
+					stats.Bytes += int64(len(s))
+				}
+			}
+			if _, err := stmt.Exec(values...); err != nil {
+				stmt.Close()
+				return stats, fmt.Errorf("Ошибка COPY в %s на сервере %s: %v", t, server, err)
+			}
+			stats.Rows++
+		}
+		_, err = stmt.Exec()
+		if closeErr := stmt.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return stats, fmt.Errorf("Ошибка завершения COPY в %s на сервере %s: %v", t, server, err)
+		}
+	}
+
+	// Явно заданные значения столбцов с последовательностями не продвигают их
+	if err := advanceSequences(tx, []*tableInfo{generated}, server); err != nil {
+		return stats, err
+	}
+	if err := tx.Commit(); err != nil {
+		return stats, fmt.Errorf("Ошибка фиксации транзакции на сервере %s: %v", server, err)
+	}
+	stats.Elapsed = time.Since(start)
+	return stats, nil
+}
+
+// Генерация строк всех таблиц описания на сервере. onlyEmpty - пропускать таблицы,
+// в которых уже есть строки. Возвращает число добавленных строк
+func generateData(db *sql.DB, server string, spec *generateSpec, truncate, onlyEmpty bool) (int64, error) {
+	seed := spec.seed()
+	var total int64
+	for i, ts := range spec.Tables {
+		if onlyEmpty {
+			schema, name := splitTableName(ts.Table)
+			var filled bool
+			query := fmt.Sprintf("SELECT EXISTS (SELECT 1 FROM %s.%s)", pq.QuoteIdentifier(schema), pq.QuoteIdentifier(name))
+			if err := db.QueryRow(query).Scan(&filled); err != nil {
+				return total, fmt.Errorf("Ошибка проверки таблицы %s на сервере %s: %v", ts.Table, server, err)
+			}
+			if filled {
+				continue
+			}
+		}
+		// У каждой таблицы своя последовательность случайных чисел, чтобы изменение
+		// одной таблицы описания не меняло данные остальных
+		stats, err := generateTable(db, server, ts, seed+int64(i), truncate)
+		if err != nil {
+			return total, err
+		}
+		fmt.Printf("Таблица %s на сервере %s: сгенерировано %s\n", ts.Table, server, stats)
+		total += stats.Rows
+	}
+	return total, nil
+}
+
+// Генерация данных на БД database кластера (команда generate)
+func generateCluster(cluster Cluster, database string, spec *generateSpec, truncate bool) error {
+	db, err := openDB(cluster.connParams().conninfo(), database)
+	if err != nil {
+		return fmt.Errorf("Ошибка подключения к серверу %s: %v", cluster.Name, err)
+	}
+	defer db.Close()
+	_, err = generateData(db, cluster.Name, spec, truncate, false)
+	return err
+}
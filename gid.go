@@ -0,0 +1,82 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Префикс GID подготовленных транзакций, созданных программой
+const gidPrefix = "dba_ali"
+
+// Максимальная длина GID: в PostgreSQL идентификатор короче 200 байт
+const maxGIDLength = 199
+
+// Идентификатор запуска передачи данных: время запуска и случайный суффикс, например 20241018T153045-9f2c4a1b
+func newRunID() string {
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		// Без случайного суффикса уникальность обеспечивают наносекунды
+		return time.Now().UTC().Format("20060102T150405.000000000")
+	}
+	return time.Now().UTC().Format("20060102T150405") + "-" + hex.EncodeToString(suffix)
+}
+
+// Формирование GID для участника запуска: dba_ali:<запуск>:<кластер>:<время в мс>.
+// Двоеточия в имени кластера заменяются, слишком длинное имя обрезается
+func newGID(runID, cluster string) string {
+	cluster = strings.ReplaceAll(cluster, ":", "_")
+	stamp := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	fixed := len(gidPrefix) + len(runID) + len(stamp) + 3
+	if fixed+len(cluster) > maxGIDLength {
+		cluster = truncateUTF8(cluster, maxGIDLength-fixed)
+	}
+	return strings.Join([]string{gidPrefix, runID, cluster, stamp}, ":")
+}
+
+// Обрезка строки до n байт без разрыва многобайтового символа
+func truncateUTF8(s string, n int) string {
+	if n <= 0 {
+		return ""
+	}
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// Составные части GID, созданного программой
+type gidInfo struct {
+	RunID   string
+	Cluster string
+	Time    time.Time
+}
+
+// Разбор GID. Возвращает false, если транзакция создана не программой
+func parseGID(gid string) (gidInfo, bool) {
+	parts := strings.Split(gid, ":")
+	if len(parts) != 4 || parts[0] != gidPrefix {
+		return gidInfo{}, false
+	}
+	ms, err := strconv.ParseInt(parts[3], 10, 64)
+	if err != nil {
+		return gidInfo{}, false
+	}
+	return gidInfo{RunID: parts[1], Cluster: parts[2], Time: time.UnixMilli(ms)}, true
+}
+
+// Описание GID для вывода: запуск и участник, если GID создан программой
+func describeGID(gid string) string {
+	info, ok := parseGID(gid)
+	if !ok {
+		return "создана не DBA_Ali"
+	}
+	return fmt.Sprintf("запуск %s, участник %s", info.RunID, info.Cluster)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseGID(t *testing.T) {
+	tests := []struct {
+		gid  string
+		want gidInfo
+		ok   bool
+	}{
+		{"dba_ali:20241018T153045-9f2c4a1b:A:1729265445123",
+			gidInfo{RunID: "20241018T153045-9f2c4a1b", Cluster: "A", Time: time.UnixMilli(1729265445123)}, true},
+		{"dba_ali:run:cluster_with_colon:0", gidInfo{RunID: "run", Cluster: "cluster_with_colon", Time: time.UnixMilli(0)}, true},
+		{"", gidInfo{}, false},
+		{"other:run:A:1729265445123", gidInfo{}, false},
+		{"dba_ali:run:A", gidInfo{}, false},
+		{"dba_ali:run:A:B:1729265445123", gidInfo{}, false},
+		{"dba_ali:run:A:notatime", gidInfo{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseGID(tt.gid)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("parseGID(%q) = %+v, %v, want %+v, %v", tt.gid, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestNewGIDParses(t *testing.T) {
+	runID := newRunID()
+	for _, cluster := range []string{"A", "host:5432", strings.Repeat("кластер", 40)} {
+		gid := newGID(runID, cluster)
+		if len(gid) > maxGIDLength {
+			t.Errorf("newGID(%q) has %d bytes, want at most %d", cluster, len(gid), maxGIDLength)
+		}
+		info, ok := parseGID(gid)
+		if !ok || info.RunID != runID || !strings.HasPrefix(strings.ReplaceAll(cluster, ":", "_"), info.Cluster) {
+			t.Errorf("parseGID(newGID(%q)) = %+v, %v", cluster, info, ok)
+		}
+	}
+}
@@ -4,5 +4,8 @@ go 1.23.2
 
 require (
 	github.com/lib/pq v1.10.9
+	golang.org/x/sys v0.26.0
+	golang.org/x/term v0.25.0
 	golang.org/x/text v0.19.0
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Типы подключений в pg_hba.conf
+var hbaTypes = []string{"local", "host", "hostssl", "hostnossl", "hostgssenc", "hostnogssenc"}
+
+// Методы аутентификации, которые можно задать при создании кластера и в правилах pg_hba.conf
+var authMethods = []string{"trust", "reject", "scram-sha-256", "md5", "password", "peer", "ident", "cert"}
+
+// Методы аутентификации, для которых суперпользователю нужен пароль
+func passwordAuth(method string) bool {
+	return method == "scram-sha-256" || method == "md5" || method == "password"
+}
+
+// Методы аутентификации кластера для подключений local и host с учётом общего метода auth
+func (c Cluster) authMethods() (local, host string, err error) {
+	local, host = c.AuthLocal, c.AuthHost
+	if local == "" {
+		local = c.Auth
+	}
+	if host == "" {
+		host = c.Auth
+	}
+	for _, method := range []string{local, host} {
+		if method == "" {
+			continue
+		}
+		if err := checkAuthMethod(method); err != nil {
+			return "", "", err
+		}
+	}
+	if local == "cert" {
+		return "", "", fmt.Errorf("Метод cert недоступен для подключений local (укажите auth_local)")
+	}
+	if host == "peer" {
+		return "", "", fmt.Errorf("Метод peer недоступен для подключений host (укажите auth_host)")
+	}
+	return local, host, nil
+}
+
+// Правило pg_hba.conf
+type hbaRule struct {
+	Type     string
+	Database string
+	User     string
+	Address  string // Адрес или адрес и маска через пробел. Пусто для local
+	Method   string
+	Options  string // Параметры метода name=value через пробел
+}
+
+func (r hbaRule) String() string {
+	line := fmt.Sprintf("%-7s %-15s %-15s %-23s %s", r.Type, r.Database, r.User, r.Address, r.Method)
+	if r.Options != "" {
+		line += " " + r.Options
+	}
+	return line
+}
+
+// Правило относится к тем же подключениям (тип, БД, пользователь, адрес), что и other
+func (r hbaRule) sameTarget(other hbaRule) bool {
+	return r.Type == other.Type && r.Database == other.Database && r.User == other.User && r.Address == other.Address
+}
+
+// Проверка метода аутентификации
+func checkAuthMethod(method string) error {
+	for _, m := range authMethods {
+		if m == method {
+			return nil
+		}
+	}
+	return fmt.Errorf("Неизвестный метод аутентификации %q (допустимо: %s)", method, strings.Join(authMethods, ", "))
+}
+
+// Проверка правила перед записью в pg_hba.conf
+func (r hbaRule) validate() error {
+	known := false
+	for _, t := range hbaTypes {
+		known = known || t == r.Type
+	}
+	if !known {
+		return fmt.Errorf("Неизвестный тип подключения %q (допустимо: %s)", r.Type, strings.Join(hbaTypes, ", "))
+	}
+	if r.Database == "" || r.User == "" {
+		return fmt.Errorf("В правиле pg_hba.conf не указаны БД или пользователь")
+	}
+	if r.Type == "local" && r.Address != "" {
+		return fmt.Errorf("У правила local не указывается адрес")
+	}
+	if r.Type != "local" && r.Address == "" {
+		return fmt.Errorf("У правила %s не указан адрес", r.Type)
+	}
+	if err := checkAuthMethod(r.Method); err != nil {
+		return err
+	}
+	switch {
+	case r.Method == "cert" && r.Type != "hostssl":
+		return fmt.Errorf("Метод cert допустим только для подключений hostssl")
+	case r.Method == "peer" && r.Type != "local":
+		return fmt.Errorf("Метод peer допустим только для подключений local")
+	}
+	return nil
+}
+
+// Путь к pg_hba.conf кластера
+func (c Cluster) hbaFile() string {
+	return filepath.Join(c.DataDir, "pg_hba.conf")
+}
+
+// Разбор строки pg_hba.conf. Возвращает false для пустых строк, комментариев и директив include
+func parseHbaLine(line string) (hbaRule, bool) {
+	if i := strings.Index(line, "#"); i >= 0 {
+		line = line[:i]
+	}
+	fields := strings.Fields(line)
+	if len(fields) < 4 || strings.HasPrefix(fields[0], "include") {
+		return hbaRule{}, false
+	}
+	rule := hbaRule{Type: fields[0], Database: fields[1], User: fields[2]}
+	rest := fields[3:]
+	if rule.Type != "local" {
+		rule.Address, rest = rest[0], rest[1:]
+		// Адрес может быть записан как IP-адрес и маска в отдельных полях
+		if !strings.Contains(rule.Address, "/") && net.ParseIP(rule.Address) != nil && len(rest) > 0 && net.ParseIP(rest[0]) != nil {
+			rule.Address, rest = rule.Address+" "+rest[0], rest[1:]
+		}
+	}
+	if len(rest) == 0 {
+		return hbaRule{}, false
+	}
+	rule.Method, rule.Options = rest[0], strings.Join(rest[1:], " ")
+	return rule, true
+}
+
+// Чтение pg_hba.conf кластера: строки файла как есть
+func readHbaLines(cluster Cluster) ([]string, error) {
+	data, err := os.ReadFile(cluster.hbaFile())
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка чтения %s: %v", cluster.hbaFile(), err)
+	}
+	return strings.Split(strings.TrimRight(string(data), "\n"), "\n"), nil
+}
+
+// Запись pg_hba.conf кластера
+func writeHbaLines(cluster Cluster, lines []string) error {
+	if err := os.WriteFile(cluster.hbaFile(), []byte(strings.Join(lines, "\n")+"\n"), 0o600); err != nil {
+		return fmt.Errorf("Ошибка записи %s: %v", cluster.hbaFile(), err)
+	}
+	return nil
+}
+
+// Правила pg_hba.conf кластера в порядке проверки сервером
+func hbaRules(cluster Cluster) ([]hbaRule, error) {
+	lines, err := readHbaLines(cluster)
+	if err != nil {
+		return nil, err
+	}
+	var rules []hbaRule
+	for _, line := range lines {
+		if rule, ok := parseHbaLine(line); ok {
+			rules = append(rules, rule)
+		}
+	}
+	return rules, nil
+}
+
+// Добавление правила в pg_hba.conf. Правило с теми же типом, БД, пользователем и адресом
+// заменяется на месте, иначе новое правило ставится перед остальными, так как сервер
+// применяет первое подходящее правило
+func addHbaRule(cluster Cluster, rule hbaRule) error {
+	if err := rule.validate(); err != nil {
+		return err
+	}
+	lines, err := readHbaLines(cluster)
+	if err != nil {
+		return err
+	}
+	first := -1
+	for i, line := range lines {
+		existing, ok := parseHbaLine(line)
+		if !ok {
+			continue
+		}
+		if existing.sameTarget(rule) {
+			lines[i] = rule.String()
+			return writeHbaLines(cluster, lines)
+		}
+		if first < 0 {
+			first = i
+		}
+	}
+	if first < 0 {
+		first = len(lines)
+	}
+	lines = append(lines[:first], append([]string{rule.String()}, lines[first:]...)...)
+	return writeHbaLines(cluster, lines)
+}
+
+// Удаление правил pg_hba.conf с теми же типом, БД, пользователем и адресом (и методом, если он задан).
+// Возвращает число удалённых правил
+func removeHbaRule(cluster Cluster, rule hbaRule) (int, error) {
+	lines, err := readHbaLines(cluster)
+	if err != nil {
+		return 0, err
+	}
+	kept := lines[:0]
+	removed := 0
+	for _, line := range lines {
+		if existing, ok := parseHbaLine(line); ok && existing.sameTarget(rule) && (rule.Method == "" || existing.Method == rule.Method) {
+			removed++
+			continue
+		}
+		kept = append(kept, line)
+	}
+	if removed == 0 {
+		return 0, fmt.Errorf("В %s нет правила %s", cluster.hbaFile(), strings.Join(strings.Fields(rule.String()), " "))
+	}
+	return removed, writeHbaLines(cluster, kept)
+}
+
+// Перевод правил host с методом cert в hostssl: initdb записывает host, а сервер принимает
+// cert только для подключений SSL
+func hbaCertRulesToSSL(cluster Cluster) error {
+	lines, err := readHbaLines(cluster)
+	if err != nil {
+		return err
+	}
+	for i, line := range lines {
+		if rule, ok := parseHbaLine(line); ok && rule.Type == "host" && rule.Method == "cert" {
+			rule.Type = "hostssl"
+			lines[i] = rule.String()
+		}
+	}
+	return writeHbaLines(cluster, lines)
+}
+
+// Изменение pg_hba.conf кластера и перечитывание конфигурации работающего сервера.
+// action - list, add или remove
+func editHba(cluster Cluster, action string, rule hbaRule) error {
+	if err := cluster.managed(); err != nil {
+		return err
+	}
+	if !isClusterDir(cluster.DataDir) {
+		return fmt.Errorf("Невозможно изменить pg_hba.conf: по пути %s нет кластера", cluster.DataDir)
+	}
+	switch action {
+	case "list":
+		rules, err := hbaRules(cluster)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Правила pg_hba.conf сервера %s:\n", cluster.Name)
+		for _, r := range rules {
+			fmt.Println("  " + r.String())
+		}
+		return nil
+	case "add":
+		if err := addHbaRule(cluster, rule); err != nil {
+			return err
+		}
+		fmt.Printf("Правило добавлено в pg_hba.conf сервера %s: %s\n", cluster.Name, strings.Join(strings.Fields(rule.String()), " "))
+	case "remove":
+		removed, err := removeHbaRule(cluster, rule)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Из pg_hba.conf сервера %s удалено правил: %d\n", cluster.Name, removed)
+	}
+	reloaded, err := reloadCluster(cluster)
+	if err != nil {
+		return err
+	}
+	if reloaded {
+		fmt.Printf("Сервер %s перечитал конфигурацию\n", cluster.Name)
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import "testing"
+
+func TestParseHbaLine(t *testing.T) {
+	tests := []struct {
+		line string
+		want hbaRule
+		ok   bool
+	}{
+		{"", hbaRule{}, false},
+		{"# TYPE  DATABASE        USER            ADDRESS                 METHOD", hbaRule{}, false},
+		{"include_dir conf.d", hbaRule{}, false},
+		{"include_if_exists extra.conf extra", hbaRule{}, false},
+		{"local   all             all                                     peer",
+			hbaRule{Type: "local", Database: "all", User: "all", Method: "peer"}, true},
+		{"local all all", hbaRule{}, false},
+		{"host    all             all             127.0.0.1/32            scram-sha-256",
+			hbaRule{Type: "host", Database: "all", User: "all", Address: "127.0.0.1/32", Method: "scram-sha-256"}, true},
+		{"host all all ::1/128 trust # IPv6",
+			hbaRule{Type: "host", Database: "all", User: "all", Address: "::1/128", Method: "trust"}, true},
+		{"hostssl app dba 10.0.0.0 255.0.0.0 cert clientcert=verify-full map=certs",
+			hbaRule{Type: "hostssl", Database: "app", User: "dba", Address: "10.0.0.0 255.0.0.0", Method: "cert",
+				Options: "clientcert=verify-full map=certs"}, true},
+		{"host all all 10.0.0.0 255.0.0.0", hbaRule{}, false},
+		{"host all all 10.0.0.1 md5",
+			hbaRule{Type: "host", Database: "all", User: "all", Address: "10.0.0.1", Method: "md5"}, true},
+		{"host all all db.example.com md5",
+			hbaRule{Type: "host", Database: "all", User: "all", Address: "db.example.com", Method: "md5"}, true},
+		{"host all all samenet", hbaRule{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseHbaLine(tt.line)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("parseHbaLine(%q) = %+v, %v, want %+v, %v", tt.line, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestHbaRuleStringParses(t *testing.T) {
+	for _, rule := range []hbaRule{
+		{Type: "local", Database: "all", User: "postgres", Method: "peer"},
+		{Type: "host", Database: "all", User: "all", Address: "192.168.0.0 255.255.0.0", Method: "md5"},
+		{Type: "hostssl", Database: "app", User: "dba", Address: "0.0.0.0/0", Method: "cert", Options: "clientcert=verify-full"},
+	} {
+		got, ok := parseHbaLine(rule.String())
+		if !ok || got != rule {
+			t.Errorf("parseHbaLine(%q) = %+v, %v, want %+v", rule.String(), got, ok, rule)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// Файл инвентаря по умолчанию, ищется в текущем каталоге
+const defaultInventoryFile = "inventory.yaml"
+
+// Переменная окружения с путём к файлу инвентаря
+const inventoryEnv = "DBA_INVENTORY"
+
+// Описание кластера в инвентаре
+type Cluster struct {
+	Name          string   `yaml:"name"`           // Уникальное имя кластера
+	DataDir       string   `yaml:"data_dir"`       // Каталог данных. Пустой, если кластер не управляется локально
+	Host          string   `yaml:"host"`           // Хост для подключения (по умолчанию localhost)
+	Port          int      `yaml:"port"`           // Порт (по умолчанию 5432)
+	Superuser     string   `yaml:"superuser"`      // Суперпользователь (по умолчанию postgres)
+	Password      string   `yaml:"password"`       // Пароль суперпользователя (лучше PGPASSWORD или .pgpass)
+	Service       string   `yaml:"service"`        // Служба из pg_service.conf с параметрами подключения
+	SSL           string   `yaml:"ssl"`            // Устарело: y - sslmode require, n - disable
+	SSLMode       string   `yaml:"sslmode"`        // Режим SSL: disable (по умолчанию), allow, prefer, require, verify-ca, verify-full
+	SSLRootCert   string   `yaml:"sslrootcert"`    // Корневой сертификат для проверки сервера
+	SSLCert       string   `yaml:"sslcert"`        // Сертификат клиента
+	SSLKey        string   `yaml:"sslkey"`         // Ключ сертификата клиента
+	Auth          string   `yaml:"auth"`           // Метод аутентификации, если не заданы auth_local и auth_host
+	AuthLocal     string   `yaml:"auth_local"`     // Метод для подключений через Unix-сокет (initdb --auth-local)
+	AuthHost      string   `yaml:"auth_host"`      // Метод для подключений по TCP (initdb --auth-host)
+	InitdbOptions []string `yaml:"initdb_options"` // Дополнительные аргументы initdb
+	PgBin         string   `yaml:"pgbin"`          // Каталог initdb и pg_ctl (по умолчанию общий pgbin инвентаря)
+	SocketDir     string   `yaml:"socket_dir"`     // Каталог Unix-сокета запускаемого сервера
+	LogFile       string   `yaml:"log_file"`       // Журнал сервера (по умолчанию server.log в каталоге данных)
+	Timeout       int      `yaml:"timeout"`        // Время ожидания запуска и остановки в секундах (по умолчанию 60)
+	AllocatePort  bool     `yaml:"allocate_port"`  // Выделять следующий свободный порт, если заданный занят
+	TLS           bool     `yaml:"tls"`            // Включать TLS при создании кластера
+	TLSDir        string   `yaml:"tls_dir"`        // Каталог удостоверяющего центра (по умолчанию общий tls_dir инвентаря)
+}
+
+// Пара серверов и параметры передачи данных по умолчанию
+type transferDefaults struct {
+	Source          string `yaml:"source"`
+	Destination     string `yaml:"destination"`
+	transferOptions `yaml:",inline"`
+}
+
+// Инвентарь управляемых кластеров
+type Inventory struct {
+	PgBin          string           `yaml:"pgbin"`           // Каталог утилит PostgreSQL (по умолчанию $PGBIN, PATH, pg_config)
+	CoordinatorLog string           `yaml:"coordinator_log"` // Журнал координатора 2PC (по умолчанию в каталоге данных по умолчанию)
+	TLSDir         string           `yaml:"tls_dir"`         // Каталог локального удостоверяющего центра (по умолчанию tls в каталоге данных по умолчанию)
+	Clusters       []Cluster        `yaml:"clusters"`
+	Transfer       transferDefaults `yaml:"transfer"`
+}
+
+// Инвентарь, используемый при отсутствии файла: тестовые сервера А и Б в каталоге по умолчанию для ОС
+func defaultInventory() *Inventory {
+	root := defaultDataRoot()
+	inv := &Inventory{
+		Clusters: []Cluster{
+			{Name: "Server_A", DataDir: filepath.Join(root, "Server_A"), Port: 33555},
+			{Name: "Server_B", DataDir: filepath.Join(root, "Server_B"), Port: 33556},
+		},
+		Transfer: transferDefaults{Source: "Server_A", Destination: "Server_B"},
+	}
+	inv.applyDefaults()
+	inv.applyAllocatedPorts()
+	return inv
+}
+
+// Загрузка инвентаря. path - путь к файлу. Если путь пустой, используется $DBA_INVENTORY,
+// затем inventory.yaml в текущем каталоге, иначе инвентарь по умолчанию
+func loadInventory(path string) (*Inventory, error) {
+	if path == "" {
+		path = os.Getenv(inventoryEnv)
+	}
+	if path == "" {
+		if _, err := os.Stat(defaultInventoryFile); err != nil {
+			inv := defaultInventory()
+			return inv, inv.applyServices()
+		}
+		path = defaultInventoryFile
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка чтения инвентаря %s: %v", path, err)
+	}
+	inv := &Inventory{}
+	if err := yaml.Unmarshal(data, inv); err != nil {
+		return nil, fmt.Errorf("Ошибка разбора инвентаря %s: %v", path, err)
+	}
+	if err := inv.applyServices(); err != nil {
+		return nil, fmt.Errorf("Некорректный инвентарь %s: %v", path, err)
+	}
+	inv.applyDefaults()
+	if err := inv.validate(); err != nil {
+		return nil, fmt.Errorf("Некорректный инвентарь %s: %v", path, err)
+	}
+	inv.applyAllocatedPorts()
+	return inv, nil
+}
+
+// Заполнение параметров подключения кластеров из служб pg_service.conf
+func (inv *Inventory) applyServices() error {
+	for i := range inv.Clusters {
+		if err := inv.Clusters[i].applyService(); err != nil {
+			return err
+		}
+		registerSecret(inv.Clusters[i].Password)
+	}
+	return nil
+}
+
+// Заполнение незаданных полей значениями по умолчанию
+func (inv *Inventory) applyDefaults() {
+	if inv.PgBin == "" {
+		inv.PgBin = os.Getenv(pgBinEnv)
+	}
+	for i := range inv.Clusters {
+		c := &inv.Clusters[i]
+		if c.PgBin == "" {
+			c.PgBin = inv.PgBin
+		}
+		if c.TLSDir == "" {
+			c.TLSDir = inv.TLSDir
+		}
+		if c.Host == "" {
+			c.Host = "localhost"
+			if c.SocketDir != "" {
+				c.Host = c.SocketDir
+			}
+		}
+		if c.Port == 0 {
+			c.Port = 5432
+		}
+		if c.Superuser == "" {
+			c.Superuser = "postgres"
+		}
+	}
+	if inv.Transfer.Source == "" && len(inv.Clusters) > 0 {
+		inv.Transfer.Source = inv.Clusters[0].Name
+	}
+	if inv.Transfer.Destination == "" && len(inv.Clusters) > 1 {
+		inv.Transfer.Destination = inv.Clusters[1].Name
+	}
+}
+
+// Проверка корректности инвентаря
+func (inv *Inventory) validate() error {
+	if len(inv.Clusters) == 0 {
+		return fmt.Errorf("не описано ни одного кластера")
+	}
+	seen := make(map[string]bool)
+	for _, c := range inv.Clusters {
+		if c.Name == "" {
+			return fmt.Errorf("у кластера не указано имя")
+		}
+		if seen[c.Name] {
+			return fmt.Errorf("имя кластера %s повторяется", c.Name)
+		}
+		seen[c.Name] = true
+		if c.Timeout < 0 {
+			return fmt.Errorf("некорректное время ожидания %d у кластера %s", c.Timeout, c.Name)
+		}
+		if c.Port < 1 || c.Port > 65535 {
+			return fmt.Errorf("некорректный порт %d у кластера %s", c.Port, c.Name)
+		}
+		if err := checkSSLMode(normalizeSSLMode(c.connParams().SSLMode)); err != nil {
+			return fmt.Errorf("кластер %s: %v", c.Name, err)
+		}
+		if _, _, err := c.authMethods(); err != nil {
+			return fmt.Errorf("кластер %s: %v", c.Name, err)
+		}
+	}
+	for _, name := range []string{inv.Transfer.Source, inv.Transfer.Destination} {
+		if name != "" && !seen[name] {
+			return fmt.Errorf("кластер %s для передачи данных не описан", name)
+		}
+	}
+	return nil
+}
+
+// Поиск кластера по имени
+func (inv *Inventory) Cluster(name string) (Cluster, error) {
+	for _, c := range inv.Clusters {
+		if c.Name == name {
+			return c, nil
+		}
+	}
+	return Cluster{}, fmt.Errorf("Кластер %s не найден в инвентаре", name)
+}
+
+// Проверка, что кластер управляется локально (для него указан каталог данных)
+func (c Cluster) managed() error {
+	if c.DataDir == "" {
+		return fmt.Errorf("Кластер %s не управляется локально: не указан data_dir", c.Name)
+	}
+	return nil
+}
+
+// Параметры подключения к кластеру
+func (c Cluster) connParams() connParams {
+	sslMode := c.SSLMode
+	if sslMode == "" {
+		sslMode = c.SSL
+	}
+	return connParams{
+		User:        c.Superuser,
+		Password:    c.Password,
+		Host:        c.Host,
+		Port:        strconv.Itoa(c.Port),
+		SSLMode:     sslMode,
+		SSLRootCert: c.SSLRootCert,
+		SSLCert:     c.SSLCert,
+		SSLKey:      c.SSLKey,
+	}
+}
@@ -0,0 +1,40 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// Захват исключительной блокировки файла. wait - ждать освобождения, иначе при занятой
+// блокировке возвращается errLogLocked
+func lockFile(f *os.File, wait bool) error {
+	how := syscall.LOCK_EX
+	if !wait {
+		how |= syscall.LOCK_NB
+	}
+	for {
+		err := syscall.Flock(int(f.Fd()), how)
+		switch {
+		case errors.Is(err, syscall.EINTR):
+			continue
+		case errors.Is(err, syscall.EWOULDBLOCK):
+			return errLogLocked
+		}
+		return err
+	}
+}
+
+// Снятие блокировки файла
+func unlockFile(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
+
+// Проверка, что процесс с указанным PID существует. EPERM означает, что процесс есть,
+// но принадлежит другому пользователю
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
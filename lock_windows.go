@@ -0,0 +1,52 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"golang.org/x/sys/windows"
+	"os"
+)
+
+// Код завершения процесса, который ещё работает
+const stillActive = 259
+
+// Блокируется байт за концом файла: заблокированную область в Windows нельзя прочитать,
+// а владелец блокировки записан в начале файла
+func lockRange() *windows.Overlapped {
+	return &windows.Overlapped{OffsetHigh: 1}
+}
+
+// Захват исключительной блокировки файла. wait - ждать освобождения, иначе при занятой
+// блокировке возвращается errLogLocked
+func lockFile(f *os.File, wait bool) error {
+	flags := uint32(windows.LOCKFILE_EXCLUSIVE_LOCK)
+	if !wait {
+		flags |= windows.LOCKFILE_FAIL_IMMEDIATELY
+	}
+	err := windows.LockFileEx(windows.Handle(f.Fd()), flags, 0, 1, 0, lockRange())
+	if errors.Is(err, windows.ERROR_LOCK_VIOLATION) {
+		return errLogLocked
+	}
+	return err
+}
+
+// Снятие блокировки файла
+func unlockFile(f *os.File) error {
+	return windows.UnlockFileEx(windows.Handle(f.Fd()), 0, 1, 0, lockRange())
+}
+
+// Проверка, что процесс с указанным PID существует. Отказ в доступе означает, что процесс
+// есть, но принадлежит другому пользователю
+func processAlive(pid int) bool {
+	h, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return errors.Is(err, windows.ERROR_ACCESS_DENIED)
+	}
+	defer windows.CloseHandle(h)
+	var code uint32
+	if err := windows.GetExitCodeProcess(h, &code); err != nil {
+		return true
+	}
+	return code == stillActive
+}
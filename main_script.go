@@ -1,34 +1,69 @@
 package main
 
 import (
-	"bytes"
 	"fmt"
-	"golang.org/x/text/encoding/charmap"
-	"golang.org/x/text/transform"
-	"io/ioutil"
 	"os"
-	"os/exec"
-	"strings"
+	"strconv"
+	"time"
 )
 
-// Функция для декдоирования стандартного вывода из окна PowerShell
-func decodeOutput(ouput []byte) (string, error) {
-	reader := transform.NewReader(bytes.NewReader(ouput), charmap.Windows1251.NewDecoder())
-	decodeBytes, err := ioutil.ReadAll(reader)
+// Функция для создания кластера. cluster - описание кластера из инвентаря
+func createCluser(cluster Cluster) error {
+	if err := cluster.managed(); err != nil {
+		return err
+	}
+	authLocal, authHost, err := cluster.authMethods()
 	if err != nil {
-		return "", err
+		return fmt.Errorf("Ошибка при создании сервера %s: %v", cluster.Name, err)
 	}
-	return string(decodeBytes), nil
-}
-
-// Функция для создания кластера. clusterPath - путь к кластеру
-func createCluser(clusterPath string) error {
-	cmd := exec.Command("initdb", "-D", clusterPath, "--username=postgres")
-	output, err := cmd.Output()
+	args := []string{"-D", cluster.DataDir, "--username=" + cluster.Superuser}
+	if authLocal != "" {
+		args = append(args, "--auth-local="+authLocal)
+	}
+	if authHost != "" {
+		args = append(args, "--auth-host="+authHost)
+	}
+	password, err := superuserPassword(cluster, passwordAuth(authLocal) || passwordAuth(authHost))
 	if err != nil {
-		return fmt.Errorf("Ошибка при создании сервера: %v, вывод: %s", err, output)
+		return fmt.Errorf("Ошибка при создании сервера %s: %v", cluster.Name, err)
+	}
+	if password != "" {
+		// Пароль передаётся initdb через временный файл, чтобы он не попал в список процессов
+		pwfile, err := os.CreateTemp("", "dba_ali_pwfile")
+		if err != nil {
+			return fmt.Errorf("Ошибка создания файла пароля: %v", err)
+		}
+		defer os.Remove(pwfile.Name())
+		_, err = pwfile.WriteString(password + "\n")
+		if closeErr := pwfile.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("Ошибка записи файла пароля: %v", err)
+		}
+		args = append(args, "--pwfile="+pwfile.Name())
+	}
+	args = append(args, cluster.InitdbOptions...)
+	cmd, err := cluster.pgCommand("initdb", args...)
+	if err != nil {
+		return err
+	}
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		decodedOutput, _ := decodeOutput(output)
+		return fmt.Errorf("Ошибка при создании сервера: %v, вывод: %s", err, redactSecrets(decodedOutput))
+	}
+	fmt.Printf("Сервер %s успешно создан в: %s\n", cluster.Name, cluster.DataDir)
+	if authHost == "cert" {
+		// Аутентификация по сертификату работает только через SSL
+		if err := hbaCertRulesToSSL(cluster); err != nil {
+			return err
+		}
+		cluster.TLS = true
+	}
+	if cluster.TLS {
+		return enableTLS(cluster, 0)
 	}
-	fmt.Printf("Сервер успешно создан в: %s\n", clusterPath)
 	return nil
 }
 
@@ -41,93 +76,163 @@ func ClusterExsists(clusterPath string) bool {
 	return info.IsDir()
 }
 
-// Функция для проверки запущен ли кластер на данный момент. clusterPath - путь к кластеру
-func isClusterRunning(clusterPath string) (bool, error) {
+// Функция для проверки запущен ли кластер на данный момент. cluster - описание кластера из инвентаря
+func isClusterRunning(cluster Cluster) (bool, error) {
+	clusterPath := cluster.DataDir
 	if !ClusterExsists(clusterPath) {
 		return false, fmt.Errorf("Невозможно проверить статус сервера: он не существует по пути %s \n", clusterPath)
 	}
-	cmd := exec.Command("pg_ctl", "-D", clusterPath, "status")
-	output, err := cmd.CombinedOutput()
+	status, err := getClusterStatus(cluster)
 	if err != nil {
-		decodedOutput, decodeErr := decodeOutput(output)
-		if decodeErr != nil {
-			return false, fmt.Errorf("Ошибка при декодировании вывода: %v", decodeErr)
-		}
-		if strings.Contains(decodedOutput, "pg_ctl: сервер не работает") {
-			return false, nil
-		}
-		fmt.Println(string(output))
-		return false, fmt.Errorf("Ошибка при проверке статуса сервера: %v, вывод: %s", err, decodedOutput)
+		return false, err
 	}
-	return true, nil
+	if status.State == StateNotACluster {
+		return false, fmt.Errorf("Невозможно проверить статус сервера: по пути %s нет кластера", clusterPath)
+	}
+	return status.State.alive(), nil
 }
 
-// Функция для запуска кластера в работу. clusterPath - путь к кластеру. host - хост(localhost). port - порт(33555,33556)
-func StartCluster(clusterPath, host string, port int) error {
+// Функция для запуска кластера в работу. cluster - описание кластера из инвентаря
+func StartCluster(cluster Cluster) error {
+	if err := cluster.managed(); err != nil {
+		return err
+	}
+	clusterPath := cluster.DataDir
 	if !ClusterExsists(clusterPath) {
 		return fmt.Errorf("Невозможно запустить сервер: он не существует по пути %s", clusterPath)
 	}
-	running, err := isClusterRunning(clusterPath)
+	status, err := getClusterStatus(cluster)
 	if err != nil {
 		return err
 	}
-	if running {
-		return fmt.Errorf("Невозможно запустить сервер: он уже запущен по пути %s", clusterPath)
+	switch {
+	case status.State == StateNotACluster:
+		return fmt.Errorf("Невозможно запустить сервер: по пути %s нет кластера", clusterPath)
+	case status.State.alive():
+		return fmt.Errorf("Невозможно запустить сервер: он уже %s по пути %s", status.State, clusterPath)
+	case status.State == StateStalePid:
+		fmt.Printf("Сервер %s не был корректно остановлен (PID %d), запускаем\n", cluster.Name, status.PID)
 	}
-	cmd := exec.Command("pg_ctl", "-D", clusterPath, "-o", fmt.Sprintf("-p%d", port), "start")
-	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("Ошибка при запуске сервера: %v", err)
+	if err := ensurePortAvailable(&cluster); err != nil {
+		return fmt.Errorf("Невозможно запустить сервер: %v", err)
 	}
-	fmt.Printf("Сервер %s по адресу: %s:%d успешно запущен.\n", clusterPath, host, port)
+	socketDir := cluster.socketDir()
+	options := fmt.Sprintf("-p%d", cluster.Port)
+	if socketDir != "" {
+		options += fmt.Sprintf(" -k \"%s\"", socketDir)
+	}
+	timeout := cluster.timeout()
+	deadline := time.Now().Add(timeout)
+	cmd, err := cluster.pgCommand("pg_ctl", "-D", clusterPath, "-o", options, "-l", cluster.logFile(),
+		"-w", "-t", strconv.Itoa(int(timeout.Seconds())), "start")
+	if err != nil {
+		return err
+	}
+	if output, err := runPgCtl(cmd); err != nil {
+		return withLogTail(fmt.Errorf("Ошибка при запуске сервера %s: %v, вывод: %s", cluster.Name, err, output), cluster)
+	}
+	if err := waitForReady(cluster, deadline); err != nil {
+		return withLogTail(err, cluster)
+	}
+	fmt.Printf("Сервер %s по адресу: %s:%d успешно запущен.\n", cluster.Name, cluster.Host, cluster.Port)
 	return nil
 }
 
-// Функция для остановки кластера. clusterPath - путь к кластеру. host - хост(localhost). port - порт(33555, 33556)
-func StopCluster(clusterPath, host string, port int) error {
+// Функция для остановки кластера. cluster - описание кластера из инвентаря. mode - режим остановки (smart, fast, immediate)
+func StopCluster(cluster Cluster, mode string) error {
+	if err := validStopMode(mode); err != nil {
+		return err
+	}
+	if err := cluster.managed(); err != nil {
+		return err
+	}
+	clusterPath := cluster.DataDir
 	if !ClusterExsists(clusterPath) {
 		return fmt.Errorf("Невозможно остановить сервер: он не существует по пути %s", clusterPath)
 	}
-	running, err := isClusterRunning(clusterPath)
+	status, err := getClusterStatus(cluster)
 	if err != nil {
 		return err
 	}
-	if !running {
-		return fmt.Errorf("Невозможно остановить сервер %s: он уже остановлен", clusterPath)
+	switch status.State {
+	case StateNotACluster:
+		return fmt.Errorf("Невозможно остановить сервер: по пути %s нет кластера", clusterPath)
+	case StateStopped:
+		return fmt.Errorf("Невозможно остановить сервер %s: он уже остановлен", cluster.Name)
+	case StateStalePid:
+		return fmt.Errorf("Невозможно остановить сервер %s: он не работает, но остался postmaster.pid (PID %d)", cluster.Name, status.PID)
 	}
-	cmd := exec.Command("pg_ctl", "-D", clusterPath, "stop")
-	output, err := cmd.CombinedOutput()
+	timeout := cluster.timeout()
+	deadline := time.Now().Add(timeout)
+	cmd, err := cluster.pgCommand("pg_ctl", "-D", clusterPath, "-m", mode,
+		"-w", "-t", strconv.Itoa(int(timeout.Seconds())), "stop")
 	if err != nil {
-		fmt.Printf("Ошибка при остановке сервер: %v, вывод: %s", err, output)
+		return err
+	}
+	if output, err := runPgCtl(cmd); err != nil {
+		return withLogTail(fmt.Errorf("Ошибка при остановке сервера %s: %v, вывод: %s", cluster.Name, err, output), cluster)
+	}
+	if err := waitForStopped(cluster, deadline); err != nil {
+		return withLogTail(err, cluster)
 	}
-	fmt.Printf("Сервер %s по адресу: %s:%d остановлен. \n", clusterPath, host, port)
+	fmt.Printf("Сервер %s по адресу: %s:%d остановлен. \n", cluster.Name, cluster.Host, cluster.Port)
 	return nil
 }
 
-// Функция для удаления кластера. clusterPath - путь к кластеру.
-func deleteCluster(clusterPath string) error {
+// Функция для перезапуска кластера, чтобы применить параметры, требующие перезапуска. cluster - описание кластера из инвентаря
+func restartCluster(cluster Cluster) error {
+	if err := StopCluster(cluster, stopFast); err != nil {
+		return err
+	}
+	return StartCluster(cluster)
+}
+
+// Функция для перечитывания конфигурации работающего кластера. Возвращает false, если сервер не запущен
+func reloadCluster(cluster Cluster) (bool, error) {
+	status, err := getClusterStatus(cluster)
+	if err != nil || !status.State.alive() {
+		return false, err
+	}
+	cmd, err := cluster.pgCommand("pg_ctl", "-D", cluster.DataDir, "reload")
+	if err != nil {
+		return false, err
+	}
+	if output, err := runPgCtl(cmd); err != nil {
+		return false, fmt.Errorf("Ошибка при перечитывании конфигурации сервера %s: %v, вывод: %s", cluster.Name, err, output)
+	}
+	return true, nil
+}
+
+// Функция для удаления кластера. cluster - описание кластера из инвентаря
+func deleteCluster(cluster Cluster) error {
+	if err := cluster.managed(); err != nil {
+		return err
+	}
+	clusterPath := cluster.DataDir
 	if !ClusterExsists(clusterPath) {
 		return fmt.Errorf("Невозможно удалить сервер: он не существует по пути: %s", clusterPath)
 	}
-	running, err := isClusterRunning(clusterPath)
+	status, err := getClusterStatus(cluster)
 	if err != nil {
 		return err
 	}
-	if running {
-		return fmt.Errorf("Невозможно удалить сервер: он запущен по пути %s", clusterPath)
+	if status.State == StateNotACluster {
+		return fmt.Errorf("Невозможно удалить сервер: каталог %s не является кластером", clusterPath)
+	}
+	if status.State.alive() {
+		return fmt.Errorf("Невозможно удалить сервер: он %s по пути %s", status.State, clusterPath)
 	}
 
 	err = os.RemoveAll(clusterPath)
 	if err != nil {
 		return fmt.Errorf("Ошибка при удалении сервера: %v", err)
 	}
-	fmt.Printf("Сервер успешно удалён по пути: %s\n", clusterPath)
+	fmt.Printf("Сервер %s успешно удалён по пути: %s\n", cluster.Name, clusterPath)
 	return nil
 }
 
-func main() {
-	cluster1Path := "C:\\TestDir\\Server_A"
-	cluster2Path := "C:\\TestDir\\Server_B"
-
+// Интерактивное меню управления серверами из инвентаря
+func runMenu(inv *Inventory) {
 	for {
 		fmt.Println("\n Что вы хотите сделать?")
 		fmt.Println("1) Проверить наличие тестовых серверов")
@@ -136,7 +241,7 @@ func main() {
 		fmt.Println("4) Включить тестовые сервера")
 		fmt.Println("5) Выключить тестовые сервера")
 		fmt.Println("6) Проверить статус серверов")
-		fmt.Println("7) Выполнить передачу данных между серверами А и Б")
+		fmt.Printf("7) Выполнить передачу данных между серверами %s и %s\n", inv.Transfer.Source, inv.Transfer.Destination)
 		fmt.Println("8) Выход")
 		var choice int
 		fmt.Print("Введите номер действия: ")
@@ -147,76 +252,55 @@ func main() {
 		}
 		switch choice {
 		case 1:
-			if ClusterExsists(cluster1Path) { // Проверяем существует ли кластер А
-				fmt.Printf("Сервер А существует по пути %s \n", cluster1Path)
-			} else {
-				fmt.Printf("Сервер А не существует по пути %s \n", cluster1Path)
-			}
-			if ClusterExsists(cluster2Path) { // Проверяем существует ли кластер Б
-				fmt.Printf("Сервер Б существует по пути %s \n", cluster2Path)
-			} else {
-				fmt.Printf("Сервер Б не существует по пути %s \n", cluster2Path)
+			for _, c := range inv.Clusters {
+				if ClusterExsists(c.DataDir) { // Проверяем существует ли кластер
+					fmt.Printf("Сервер %s существует по пути %s \n", c.Name, c.DataDir)
+				} else {
+					fmt.Printf("Сервер %s не существует по пути %s \n", c.Name, c.DataDir)
+				}
 			}
 		case 2:
-			if err := deleteCluster(cluster1Path); err != nil {
-				fmt.Println(err) // Удаляем кластер А если не возникает ошибка
-			}
-			if err := deleteCluster(cluster2Path); err != nil {
-				fmt.Println(err) // Удаляем кластер Б если не возникает ошибка
+			for _, c := range inv.Clusters {
+				if err := deleteCluster(c); err != nil {
+					fmt.Println(err) // Удаляем кластер если не возникает ошибка
+				}
 			}
 		case 3:
-			if ClusterExsists(cluster1Path) {
-				fmt.Println("Сервер А существует, пропускаем \n") // Проверяем существует ли кластер А. И если да, то пропускаем создание
-			} else {
-				fmt.Printf("Сервер А не существует, создаём \n") // Иначе создаём
-				if err := createCluser(cluster1Path); err != nil {
-					fmt.Println(err)
-					return
+			for _, c := range inv.Clusters {
+				if ClusterExsists(c.DataDir) {
+					fmt.Printf("Сервер %s существует, пропускаем\n", c.Name) // Проверяем существует ли кластер. И если да, то пропускаем создание
+					continue
 				}
-			}
-
-			if ClusterExsists(cluster2Path) {
-				fmt.Println("Сервер Б существует, пропускаем \n") // Проверяем существует ли кластер Б. И если да, то пропускаем создание
-			} else {
-				fmt.Println("Сервер Б не существует, создаём \n") // Иначе создаём
-				if err := createCluser(cluster2Path); err != nil {
+				fmt.Printf("Сервер %s не существует, создаём\n", c.Name) // Иначе создаём
+				if err := createCluser(c); err != nil {
 					fmt.Println(err)
 					return
 				}
 			}
 		case 4:
-			if err := StartCluster(cluster1Path, "localhost", 33555); err != nil {
-				fmt.Println(err) // Запускаем кластер А если не возникает ошибок
-			}
-			if err := StartCluster(cluster2Path, "localhost", 33556); err != nil {
-				fmt.Println(err) // Запускаем кластер Б если не возникает ошибок
+			for _, c := range inv.Clusters {
+				if err := StartCluster(c); err != nil {
+					fmt.Println(err) // Запускаем кластер если не возникает ошибок
+				}
 			}
+			inv.applyAllocatedPorts() // Порты могли быть выделены автоматически при запуске
 		case 5:
-			if err := StopCluster(cluster1Path, "localhost", 33555); err != nil {
-				fmt.Println(err) // Останавливаем кластер А если не возникает ошибок
-			}
-			if err := StopCluster(cluster2Path, "localhost", 33556); err != nil {
-				fmt.Println(err) // Останавливаем кластер Б если не возникает ошибок
+			for _, c := range inv.Clusters {
+				if err := StopCluster(c, stopFast); err != nil {
+					fmt.Println(err) // Останавливаем кластер если не возникает ошибок
+				}
 			}
 		case 6:
-			running1, err := isClusterRunning(cluster1Path)
-			if err != nil {
-				fmt.Printf("Ошибка при проверке статуса сервера А: %v\n", err) // Ловим ошибки при проверке статуса кластера А
-			} else if running1 {
-				fmt.Println("Сервер А запущен.")
-			} else {
-				fmt.Println("Сервер А не запущен. ")
-			}
-			running2, err := isClusterRunning(cluster2Path)
-			if err != nil {
-				fmt.Printf("Ошибка при проверке статуса сервера Б: %v\n", err) // Ловим ошибки при проверке статуса кластера Б
-			} else if running2 {
-				fmt.Println("Сервер Б запущен.")
-			} else {
-				fmt.Println("Сервер Б не запущен. ")
+			for _, c := range inv.Clusters {
+				status, err := getClusterStatus(c)
+				if err != nil {
+					fmt.Printf("Ошибка при проверке статуса сервера %s: %v\n", c.Name, err) // Ловим ошибки при проверке статуса кластера
+				} else {
+					fmt.Printf("Сервер %s: %s.\n", c.Name, status)
+				}
 			}
 		case 7:
-			TransferData() // Запускаем TransferData из файла transfer_between_a_b.go
+			TransferData(inv) // Запускаем TransferData из файла transfer_between_a_b.go
 		case 8:
 			fmt.Println("Выходим...") // Выходим
 			return
@@ -224,5 +308,8 @@ func main() {
 			fmt.Println("Некорректный выбор, попробуйте снова.") // Если совсем не тот выбор, то говорим о том, что некорректный
 		}
 	}
+}
 
+func main() {
+	os.Exit(runCLI(os.Args[1:]))
 }
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Миграции тестовых данных, встроенные в программу. Используются, если каталог не указан
+//
+//go:embed migrations/*.sql
+var fixtureMigrations embed.FS
+
+// Таблица учёта применённых миграций в каждой целевой БД
+const schemaMigrationsTable = "schema_migrations"
+
+// Ключ рекомендательной блокировки, не дающей применять миграции одновременно
+const migrationLockKey = 20241018
+
+// Имя файла миграции: <версия>_<название>.up.sql или <версия>_<название>.down.sql
+var migrationFileName = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// Миграция: версия, название и файлы применения и отката
+type migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Каталог миграций: указанный или встроенные миграции тестовых данных
+func migrationsFS(dir string) (fs.FS, error) {
+	if dir == "" {
+		return fs.Sub(fixtureMigrations, "migrations")
+	}
+	if _, err := os.Stat(dir); err != nil {
+		return nil, fmt.Errorf("Ошибка открытия каталога миграций %s: %v", dir, err)
+	}
+	return os.DirFS(dir), nil
+}
+
+// Чтение списка миграций каталога, упорядоченного по версии
+func loadMigrations(fsys fs.FS) ([]migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка чтения каталога миграций: %v", err)
+	}
+	byVersion := make(map[int64]*migration)
+	for _, e := range entries {
+		m := migrationFileName.FindStringSubmatch(e.Name())
+		if e.IsDir() || m == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(m[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("Неверная версия миграции %s: %v", e.Name(), err)
+		}
+		mig := byVersion[version]
+		if mig == nil {
+			mig = &migration{Version: version, Name: m[2]}
+			byVersion[version] = mig
+		}
+		if mig.Name != m[2] {
+			return nil, fmt.Errorf("Версия миграции %d используется для %s и %s", version, mig.Name, m[2])
+		}
+		if m[3] == "up" {
+			mig.Up = e.Name()
+		} else {
+			mig.Down = e.Name()
+		}
+	}
+	var migrations []migration
+	for _, mig := range byVersion {
+		if mig.Up == "" {
+			return nil, fmt.Errorf("Для миграции %d_%s нет файла .up.sql", mig.Version, mig.Name)
+		}
+		migrations = append(migrations, *mig)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Создание таблицы учёта миграций, если её нет
+func ensureMigrationsTable(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS ` + schemaMigrationsTable + ` (
+		version bigint PRIMARY KEY,
+		name text NOT NULL,
+		applied_at timestamptz NOT NULL DEFAULT now())`)
+	if err != nil {
+		return fmt.Errorf("Ошибка создания таблицы %s: %v", schemaMigrationsTable, err)
+	}
+	return nil
+}
+
+// Применённые миграции: версия и время применения
+func appliedMigrations(db *sql.DB) (map[int64]time.Time, error) {
+	rows, err := db.Query("SELECT version, applied_at FROM " + schemaMigrationsTable)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка чтения таблицы %s: %v", schemaMigrationsTable, err)
+	}
+	defer rows.Close()
+	applied := make(map[int64]time.Time)
+	for rows.Next() {
+		var version int64
+		var at time.Time
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+	return applied, rows.Err()
+}
+
+// Применение (up) или откат миграции m и обновление таблицы учёта в одной транзакции.
+// Состояние миграции перечитывается под блокировкой: если другой процесс уже применил
+// или откатил её, миграция пропускается. Возвращает false для пропущенной миграции
+func runMigration(db *sql.DB, fsys fs.FS, m migration, up bool) (bool, error) {
+	file := m.Down
+	if up {
+		file = m.Up
+	}
+	script, err := fs.ReadFile(fsys, file)
+	if err != nil {
+		return false, fmt.Errorf("Ошибка чтения миграции %s: %v", file, err)
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("SELECT pg_advisory_xact_lock($1)", migrationLockKey); err != nil {
+		return false, fmt.Errorf("Ошибка блокировки таблицы %s: %v", schemaMigrationsTable, err)
+	}
+	var applied bool
+	if err := tx.QueryRow("SELECT EXISTS (SELECT 1 FROM "+schemaMigrationsTable+" WHERE version = $1)", m.Version).Scan(&applied); err != nil {
+		return false, fmt.Errorf("Ошибка чтения таблицы %s: %v", schemaMigrationsTable, err)
+	}
+	if applied == up {
+		return false, nil
+	}
+	if up {
+		fmt.Printf("Применяю миграцию %d_%s\n", m.Version, m.Name)
+	} else {
+		fmt.Printf("Откатываю миграцию %d_%s\n", m.Version, m.Name)
+	}
+	// Запрос без параметров выполняется простым протоколом, поэтому файл может содержать несколько команд
+	if _, err := tx.Exec(string(script)); err != nil {
+		return false, fmt.Errorf("Ошибка выполнения миграции %s: %v", file, err)
+	}
+	record, args := "DELETE FROM "+schemaMigrationsTable+" WHERE version = $1", []any{m.Version}
+	if up {
+		record, args = "INSERT INTO "+schemaMigrationsTable+" (version, name) VALUES ($1, $2)", []any{m.Version, m.Name}
+	}
+	if _, err := tx.Exec(record, args...); err != nil {
+		return false, fmt.Errorf("Ошибка записи в таблицу %s: %v", schemaMigrationsTable, err)
+	}
+	return true, tx.Commit()
+}
+
+// Применение неприменённых миграций по возрастанию версии. steps - не больше стольких
+// миграций (0 - все). Возвращает число применённых миграций
+func migrateUp(db *sql.DB, fsys fs.FS, steps int) (int, error) {
+	migrations, err := loadMigrations(fsys)
+	if err != nil {
+		return 0, err
+	}
+	if err := ensureMigrationsTable(db); err != nil {
+		return 0, err
+	}
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, m := range migrations {
+		if _, done := applied[m.Version]; done {
+			continue
+		}
+		if steps > 0 && count >= steps {
+			break
+		}
+		ran, err := runMigration(db, fsys, m, true)
+		if err != nil {
+			return count, err
+		}
+		if ran {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// Откат применённых миграций по убыванию версии. steps - сколько миграций откатить
+func migrateDown(db *sql.DB, fsys fs.FS, steps int) (int, error) {
+	migrations, err := loadMigrations(fsys)
+	if err != nil {
+		return 0, err
+	}
+	if err := ensureMigrationsTable(db); err != nil {
+		return 0, err
+	}
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for i := len(migrations) - 1; i >= 0 && count < steps; i-- {
+		m := migrations[i]
+		if _, done := applied[m.Version]; !done {
+			continue
+		}
+		if m.Down == "" {
+			return count, fmt.Errorf("Для миграции %d_%s нет файла .down.sql, откат невозможен", m.Version, m.Name)
+		}
+		ran, err := runMigration(db, fsys, m, false)
+		if err != nil {
+			return count, err
+		}
+		if ran {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// Вывод состояния миграций БД. Применённые версии, для которых нет файлов, тоже выводятся
+func migrationStatus(db *sql.DB, fsys fs.FS) error {
+	migrations, err := loadMigrations(fsys)
+	if err != nil {
+		return err
+	}
+	applied := make(map[int64]time.Time)
+	var exists bool
+	if err := db.QueryRow("SELECT to_regclass($1) IS NOT NULL", schemaMigrationsTable).Scan(&exists); err != nil {
+		return fmt.Errorf("Ошибка проверки таблицы %s: %v", schemaMigrationsTable, err)
+	}
+	if exists {
+		if applied, err = appliedMigrations(db); err != nil {
+			return err
+		}
+	}
+	known := make(map[int64]bool)
+	for _, m := range migrations {
+		known[m.Version] = true
+		if at, done := applied[m.Version]; done {
+			fmt.Printf("  %d_%s: применена %s\n", m.Version, m.Name, at.Local().Format("2006-01-02 15:04:05"))
+		} else {
+			fmt.Printf("  %d_%s: не применена\n", m.Version, m.Name)
+		}
+	}
+	for version, at := range applied {
+		if !known[version] {
+			fmt.Printf("  %d: применена %s, файла миграции нет\n", version, at.Local().Format("2006-01-02 15:04:05"))
+		}
+	}
+	return nil
+}
+
+// Выполнение команды миграций action (up, down, status) на БД database кластера
+func migrateCluster(cluster Cluster, database, dir, action string, steps int) error {
+	fsys, err := migrationsFS(dir)
+	if err != nil {
+		return err
+	}
+	db, err := openDB(cluster.connParams().conninfo(), database)
+	if err != nil {
+		return fmt.Errorf("Ошибка подключения к серверу %s: %v", cluster.Name, err)
+	}
+	defer db.Close()
+
+	fmt.Printf("Сервер %s, БД %s:\n", cluster.Name, database)
+	var count int
+	switch action {
+	case "up":
+		count, err = migrateUp(db, fsys, steps)
+	case "down":
+		if steps <= 0 {
+			steps = 1
+		}
+		count, err = migrateDown(db, fsys, steps)
+	case "status":
+		return migrationStatus(db, fsys)
+	default:
+		return fmt.Errorf("Неизвестное действие миграций %q (допустимо: up, down, status)", action)
+	}
+	if err != nil {
+		return fmt.Errorf("Сервер %s, БД %s: %v", cluster.Name, database, err)
+	}
+	if count == 0 {
+		fmt.Println("  нечего выполнять")
+	}
+	return nil
+}
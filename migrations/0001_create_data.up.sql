@@ -0,0 +1,5 @@
+-- Тестовая таблица для передачи данных между серверами
+CREATE TABLE IF NOT EXISTS Data (
+    id SERIAL PRIMARY KEY,
+    value VARCHAR(100)
+);
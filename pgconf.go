@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Параметр из файла конфигурации в формате postgresql.conf
+type confEntry struct {
+	Name    string
+	Value   string // Значение без кавычек
+	Comment string // Комментарий в конце строки без символа #
+}
+
+// Путь к postgresql.conf кластера
+func (c Cluster) confFile() string {
+	return filepath.Join(c.DataDir, "postgresql.conf")
+}
+
+// Разбор строки конфигурации. Возвращает false для пустых строк и комментариев
+func parseConfLine(line string) (confEntry, bool) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return confEntry{}, false
+	}
+	name, rest, found := strings.Cut(line, "=")
+	if !found {
+		// Допускается запись "name value" без знака равенства
+		name, rest, found = strings.Cut(line, " ")
+		if !found {
+			return confEntry{}, false
+		}
+	}
+	entry := confEntry{Name: strings.ToLower(strings.TrimSpace(name))}
+	rest = strings.TrimSpace(rest)
+
+	if strings.HasPrefix(rest, "'") {
+		// Значение в кавычках: '' и \' внутри означают кавычку
+		var b strings.Builder
+		i := 1
+		for ; i < len(rest); i++ {
+			ch := rest[i]
+			if ch == '\\' && i+1 < len(rest) {
+				i++
+				b.WriteByte(rest[i])
+				continue
+			}
+			if ch == '\'' {
+				if i+1 < len(rest) && rest[i+1] == '\'' {
+					b.WriteByte('\'')
+					i++
+					continue
+				}
+				break
+			}
+			b.WriteByte(ch)
+		}
+		entry.Value = b.String()
+		rest = rest[min(i+1, len(rest)):]
+	} else {
+		value, _, _ := strings.Cut(rest, "#")
+		entry.Value = strings.TrimSpace(value)
+		rest = rest[len(value):]
+	}
+	if _, comment, ok := strings.Cut(rest, "#"); ok {
+		entry.Comment = strings.TrimSpace(comment)
+	}
+	return entry, true
+}
+
+// Чтение параметра из файла конфигурации. Действует последнее вхождение параметра
+func readConfEntry(path, name string) (confEntry, bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return confEntry{}, false, nil
+		}
+		return confEntry{}, false, err
+	}
+	defer f.Close()
+
+	name = strings.ToLower(name)
+	var result confEntry
+	found := false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if entry, ok := parseConfLine(scanner.Text()); ok && entry.Name == name {
+			result, found = entry, true
+		}
+	}
+	return result, found, scanner.Err()
+}
+
+// Форматирование значения для файла конфигурации: всё, кроме простых слов и чисел, берётся в кавычки
+func confQuote(value string) string {
+	simple := value != ""
+	for _, r := range value {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '_' || r == '-') {
+			simple = false
+			break
+		}
+	}
+	if simple {
+		return value
+	}
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+// Запись параметра в файл конфигурации. Последнее вхождение параметра заменяется,
+// при его отсутствии строка добавляется в конец файла. comment - комментарий к строке (может быть пустым)
+func writeConfParameter(path, name, value, comment string) error {
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("Ошибка чтения %s: %v", path, err)
+	}
+	line := fmt.Sprintf("%s = %s", name, confQuote(value))
+	if comment != "" {
+		line += "\t# " + comment
+	}
+
+	lines := strings.Split(string(data), "\n")
+	last := -1
+	for i, l := range lines {
+		if entry, ok := parseConfLine(l); ok && entry.Name == strings.ToLower(name) {
+			last = i
+		}
+	}
+	if last >= 0 {
+		lines[last] = line
+	} else {
+		if len(lines) > 0 && lines[len(lines)-1] == "" {
+			lines = lines[:len(lines)-1]
+		}
+		lines = append(lines, line, "")
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0o600); err != nil {
+		return fmt.Errorf("Ошибка записи %s: %v", path, err)
+	}
+	return nil
+}
+
+// Удаление всех вхождений параметра из файла конфигурации
+func removeConfParameter(path, name string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Ошибка чтения %s: %v", path, err)
+	}
+	lines := strings.Split(string(data), "\n")
+	kept := lines[:0]
+	for _, l := range lines {
+		if entry, ok := parseConfLine(l); ok && entry.Name == strings.ToLower(name) {
+			continue
+		}
+		kept = append(kept, l)
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(kept, "\n")), 0o600); err != nil {
+		return fmt.Errorf("Ошибка записи %s: %v", path, err)
+	}
+	return nil
+}
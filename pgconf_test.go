@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+func TestParseConfLine(t *testing.T) {
+	tests := []struct {
+		line string
+		want confEntry
+		ok   bool
+	}{
+		{"", confEntry{}, false},
+		{"   ", confEntry{}, false},
+		{"# port = 5432", confEntry{}, false},
+		{"port = 5433", confEntry{Name: "port", Value: "5433"}, true},
+		{"port=5433", confEntry{Name: "port", Value: "5433"}, true},
+		{"Port 5433", confEntry{Name: "port", Value: "5433"}, true},
+		{"port = 5433  # порт сервера", confEntry{Name: "port", Value: "5433", Comment: "порт сервера"}, true},
+		{"listen_addresses = '*'", confEntry{Name: "listen_addresses", Value: "*"}, true},
+		{"ssl_cert_file = '/tls/server.crt'\t# TLS настроен DBA_Ali",
+			confEntry{Name: "ssl_cert_file", Value: "/tls/server.crt", Comment: "TLS настроен DBA_Ali"}, true},
+		{"log_line_prefix = '%m # %p '", confEntry{Name: "log_line_prefix", Value: "%m # %p "}, true},
+		{"search_path = 'it''s'", confEntry{Name: "search_path", Value: "it's"}, true},
+		{`search_path = 'it\'s'`, confEntry{Name: "search_path", Value: "it's"}, true},
+		{"shared_preload_libraries = ''", confEntry{Name: "shared_preload_libraries"}, true},
+		{"unterminated = 'abc", confEntry{Name: "unterminated", Value: "abc"}, true},
+		{"novalue", confEntry{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseConfLine(tt.line)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("parseConfLine(%q) = %+v, %v, want %+v, %v", tt.line, got, ok, tt.want, tt.ok)
+		}
+	}
+}
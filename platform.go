@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Переменная окружения с каталогом исполняемых файлов PostgreSQL
+const pgBinEnv = "PGBIN"
+
+// Каталог для кластеров тестовых серверов по умолчанию в зависимости от ОС
+func defaultDataRoot() string {
+	if runtime.GOOS == "windows" {
+		return "C:\\TestDir"
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(os.TempDir(), "DBA_Ali")
+	}
+	if runtime.GOOS == "darwin" {
+		return filepath.Join(home, "Library", "Application Support", "DBA_Ali")
+	}
+	if xdg := os.Getenv("XDG_DATA_HOME"); xdg != "" {
+		return filepath.Join(xdg, "DBA_Ali")
+	}
+	return filepath.Join(home, ".local", "share", "DBA_Ali")
+}
+
+// Типичные каталоги установки PostgreSQL, если утилиты не найдены в PATH
+func wellKnownPgBinGlobs() []string {
+	switch runtime.GOOS {
+	case "windows":
+		return []string{"C:\\Program Files\\PostgreSQL\\*\\bin"}
+	case "darwin":
+		return []string{
+			"/opt/homebrew/opt/postgresql*/bin",
+			"/usr/local/opt/postgresql*/bin",
+			"/Applications/Postgres.app/Contents/Versions/*/bin",
+		}
+	}
+	return []string{
+		"/usr/lib/postgresql/*/bin", // Debian, Ubuntu
+		"/usr/pgsql-*/bin",          // RHEL, CentOS (PGDG)
+		"/usr/local/pgsql/bin",      // Сборка из исходников
+	}
+}
+
+// Номер версии из пути вида /usr/lib/postgresql/16/bin для сортировки каталогов
+func pathVersion(path string) float64 {
+	for _, part := range strings.FieldsFunc(path, func(r rune) bool { return r == '/' || r == '\\' || r == '-' || r == '@' }) {
+		part = strings.TrimPrefix(part, "postgresql")
+		if v, err := strconv.ParseFloat(part, 64); err == nil {
+			return v
+		}
+	}
+	return 0
+}
+
+// Имя исполняемого файла с учётом ОС
+func executableName(name string) string {
+	if runtime.GOOS == "windows" && !strings.HasSuffix(name, ".exe") {
+		return name + ".exe"
+	}
+	return name
+}
+
+// Каталог с исполняемыми файлами, определённый pg_config --bindir
+func pgConfigBinDir() string {
+	pgConfig, err := exec.LookPath("pg_config")
+	if err != nil {
+		return ""
+	}
+	output, err := exec.Command(pgConfig, "--bindir").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(output))
+}
+
+// Кэш найденных утилит: ключ - каталог из настроек и имя утилиты
+var pgBinaryCache sync.Map
+
+// Поиск утилиты PostgreSQL (initdb, pg_ctl). pgbin - каталог из инвентаря или $PGBIN.
+// Порядок поиска: pgbin, PATH, pg_config --bindir, типичные каталоги установки
+func findPgBinary(pgbin, name string) (string, error) {
+	key := pgbin + "\x00" + name
+	if path, ok := pgBinaryCache.Load(key); ok {
+		return path.(string), nil
+	}
+
+	exe := executableName(name)
+	var candidates []string
+	if pgbin != "" {
+		path := filepath.Join(pgbin, exe)
+		if _, err := os.Stat(path); err != nil {
+			return "", fmt.Errorf("Утилита %s не найдена в каталоге %s", name, pgbin)
+		}
+		candidates = append(candidates, path)
+	}
+	if path, err := exec.LookPath(name); err == nil {
+		candidates = append(candidates, path)
+	}
+	if dir := pgConfigBinDir(); dir != "" {
+		candidates = append(candidates, filepath.Join(dir, exe))
+	}
+	for _, pattern := range wellKnownPgBinGlobs() {
+		dirs, _ := filepath.Glob(pattern)
+		sort.Slice(dirs, func(i, j int) bool { return pathVersion(dirs[i]) > pathVersion(dirs[j]) })
+		for _, dir := range dirs {
+			candidates = append(candidates, filepath.Join(dir, exe))
+		}
+	}
+
+	for _, path := range candidates {
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			pgBinaryCache.Store(key, path)
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("Утилита %s не найдена: укажите pgbin в инвентаре или переменную %s", name, pgBinEnv)
+}
+
+// Команда утилиты PostgreSQL для кластера
+func (c Cluster) pgCommand(name string, args ...string) (*exec.Cmd, error) {
+	path, err := findPgBinary(c.PgBin, name)
+	if err != nil {
+		return nil, err
+	}
+	return exec.Command(path, args...), nil
+}
+
+// Путь задаёт каталог Unix-сокета, а не сетевой хост
+func isSocketDir(host string) bool {
+	return strings.HasPrefix(host, "/")
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Комментарий, которым помечается порт, выделенный автоматически
+const allocatedPortComment = "порт выделен DBA_Ali автоматически"
+
+// Сколько портов после заданного перебирается при автоматическом выделении
+const portAllocationRange = 100
+
+// Проверка, свободен ли TCP-порт: его можно занять и к нему никто не принимает подключения
+func tcpPortFree(host string, port int) bool {
+	ln, err := net.Listen("tcp", net.JoinHostPort("", strconv.Itoa(port)))
+	if err != nil {
+		return false
+	}
+	ln.Close()
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(host, strconv.Itoa(port)), 500*time.Millisecond)
+	if err == nil {
+		conn.Close()
+		return false
+	}
+	return true
+}
+
+// Проверка, свободен ли Unix-сокет PostgreSQL для порта в каталоге dir
+func socketFree(dir string, port int) bool {
+	conn, err := net.DialTimeout("unix", filepath.Join(dir, ".s.PGSQL."+strconv.Itoa(port)), 500*time.Millisecond)
+	if err == nil {
+		conn.Close()
+		return false
+	}
+	return true
+}
+
+// Каталог Unix-сокета запускаемого сервера: явно заданный или указанный вместо хоста
+func (c Cluster) socketDir() string {
+	if c.SocketDir != "" {
+		return c.SocketDir
+	}
+	if isSocketDir(c.Host) {
+		return c.Host
+	}
+	return ""
+}
+
+// Проверка доступности порта кластера по TCP и Unix-сокету
+func portAvailable(cluster Cluster, port int) bool {
+	host := cluster.Host
+	if isSocketDir(host) {
+		host = "localhost"
+	}
+	if !tcpPortFree(host, port) {
+		return false
+	}
+	if dir := cluster.socketDir(); dir != "" && !socketFree(dir, port) {
+		return false
+	}
+	return true
+}
+
+// Процесс, слушающий TCP-порт, в виде "имя (PID n)". Пустая строка, если определить не удалось
+func portOwner(port int) string {
+	if runtime.GOOS == "windows" {
+		return portOwnerWindows(port)
+	}
+	// lsof есть на macOS и на большинстве дистрибутивов Linux
+	if output, err := exec.Command("lsof", "-nP", fmt.Sprintf("-iTCP:%d", port), "-sTCP:LISTEN", "-Fpc").Output(); err == nil {
+		var pid, name string
+		for _, line := range strings.Split(string(output), "\n") {
+			switch {
+			case strings.HasPrefix(line, "p") && pid == "":
+				pid = line[1:]
+			case strings.HasPrefix(line, "c") && name == "":
+				name = line[1:]
+			}
+		}
+		if pid != "" {
+			return fmt.Sprintf("%s (PID %s)", name, pid)
+		}
+	}
+	// ss выводит владельца в виде users:(("postgres",pid=123,fd=5))
+	if output, err := exec.Command("ss", "-Hltnp", fmt.Sprintf("sport = :%d", port)).Output(); err == nil {
+		line := string(output)
+		if i := strings.Index(line, "users:((\""); i >= 0 {
+			fields := strings.Split(line[i+len("users:((\""):], ",")
+			name := strings.TrimSuffix(fields[0], "\"")
+			if len(fields) > 1 && strings.HasPrefix(fields[1], "pid=") {
+				return fmt.Sprintf("%s (PID %s)", name, strings.TrimPrefix(fields[1], "pid="))
+			}
+			return name
+		}
+	}
+	return ""
+}
+
+// Определение владельца порта на Windows через netstat и tasklist
+func portOwnerWindows(port int) string {
+	output, err := exec.Command("netstat", "-ano", "-p", "TCP").Output()
+	if err != nil {
+		return ""
+	}
+	suffix := ":" + strconv.Itoa(port)
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		// Proto  Local Address  Foreign Address  State  PID
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 || !strings.HasSuffix(fields[1], suffix) || fields[3] != "LISTENING" {
+			continue
+		}
+		pid := fields[4]
+		tasklist, err := exec.Command("tasklist", "/FI", "PID eq "+pid, "/FO", "CSV", "/NH").Output()
+		if err != nil {
+			return "PID " + pid
+		}
+		name, _, _ := strings.Cut(strings.TrimSpace(string(tasklist)), ",")
+		return fmt.Sprintf("%s (PID %s)", strings.Trim(name, "\""), pid)
+	}
+	return ""
+}
+
+// Описание занятого порта для сообщений об ошибках
+func busyPortError(cluster Cluster, port int) error {
+	if owner := portOwner(port); owner != "" {
+		return fmt.Errorf("Порт %d для сервера %s занят процессом %s", port, cluster.Name, owner)
+	}
+	return fmt.Errorf("Порт %d для сервера %s занят", port, cluster.Name)
+}
+
+// Порт, ранее выделенный автоматически и сохранённый в postgresql.conf кластера
+func allocatedPort(cluster Cluster) (int, bool) {
+	if cluster.DataDir == "" {
+		return 0, false
+	}
+	entry, found, err := readConfEntry(cluster.confFile(), "port")
+	if err != nil || !found || entry.Comment != allocatedPortComment {
+		return 0, false
+	}
+	port, err := strconv.Atoi(entry.Value)
+	if err != nil {
+		return 0, false
+	}
+	return port, true
+}
+
+// Применение портов, выделенных автоматически, к кластерам инвентаря
+func (inv *Inventory) applyAllocatedPorts() {
+	for i := range inv.Clusters {
+		if port, ok := allocatedPort(inv.Clusters[i]); ok {
+			inv.Clusters[i].Port = port
+		}
+	}
+}
+
+// Проверка порта перед запуском кластера. Если порт занят и разрешено автоматическое
+// выделение, подбирается следующий свободный порт и сохраняется в postgresql.conf
+func ensurePortAvailable(cluster *Cluster) error {
+	if portAvailable(*cluster, cluster.Port) {
+		return nil
+	}
+	if !cluster.AllocatePort {
+		return busyPortError(*cluster, cluster.Port)
+	}
+	for port := cluster.Port + 1; port <= cluster.Port+portAllocationRange && port <= 65535; port++ {
+		if !portAvailable(*cluster, port) {
+			continue
+		}
+		if err := writeConfParameter(cluster.confFile(), "port", strconv.Itoa(port), allocatedPortComment); err != nil {
+			return err
+		}
+		fmt.Printf("Порт %d занят, сервер %s будет запущен на порту %d\n", cluster.Port, cluster.Name, port)
+		cluster.Port = port
+		return nil
+	}
+	return fmt.Errorf("Не удалось найти свободный порт для сервера %s в диапазоне %d-%d", cluster.Name, cluster.Port+1, cluster.Port+portAllocationRange)
+}
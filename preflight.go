@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Значение max_prepared_transactions, которое устанавливается при настройке кластера,
+// если текущих подготовленных транзакций меньше
+const defaultMaxPreparedTransactions = 8
+
+// Состояние подготовленных транзакций на сервере
+type preparedSlots struct {
+	Max  int // max_prepared_transactions
+	Used int // Подготовленные транзакции в pg_prepared_xacts
+}
+
+func (s preparedSlots) free() int {
+	return s.Max - s.Used
+}
+
+// Чтение max_prepared_transactions и числа подготовленных транзакций сервера
+func readPreparedSlots(server string) (preparedSlots, error) {
+	var slots preparedSlots
+	db, err := openDB(server, settingsDatabase)
+	if err != nil {
+		return slots, fmt.Errorf("Ошибка подключения к серверу: %v", err)
+	}
+	defer db.Close()
+	setting, err := readSetting(db, "max_prepared_transactions")
+	if err != nil {
+		return slots, err
+	}
+	if slots.Max, err = strconv.Atoi(setting.Setting); err != nil {
+		return slots, fmt.Errorf("Некорректное значение max_prepared_transactions %q", setting.Setting)
+	}
+	if err := db.QueryRow("SELECT count(*) FROM pg_prepared_xacts").Scan(&slots.Used); err != nil {
+		return slots, fmt.Errorf("Ошибка чтения pg_prepared_xacts: %v", err)
+	}
+	return slots, nil
+}
+
+// Проверка перед передачей, что на каждом участнике включены подготовленные транзакции и есть
+// свободные места: каждый участник держит одну подготовленную транзакцию за раз. Управляемый
+// кластер с нехваткой мест настраивается и перезапускается, если это разрешено флагом
+// configure_prepared или подтверждено пользователем
+func checkPreparedTransactions(participants []participant, opts transferOptions) error {
+	needed := make(map[string]int)
+	for _, p := range participants {
+		needed[p.Cluster.Name]++
+	}
+	checked := make(map[string]bool)
+	for _, p := range participants {
+		if checked[p.Cluster.Name] {
+			continue
+		}
+		checked[p.Cluster.Name] = true
+		slots, err := readPreparedSlots(p.Server)
+		if err != nil {
+			return newTransferError(phasePreflight, p.Cluster.Name, err)
+		}
+		need := needed[p.Cluster.Name]
+		if slots.free() >= need {
+			continue
+		}
+
+		problem := fmt.Sprintf("подготовленные транзакции отключены (max_prepared_transactions = %d)", slots.Max)
+		if slots.Max > 0 {
+			problem = fmt.Sprintf("нет свободных мест для подготовленных транзакций (max_prepared_transactions = %d, занято %d)", slots.Max, slots.Used)
+		}
+		if slots.Used > 0 {
+			fmt.Printf("На сервере %s есть подготовленные транзакции (%d): проверьте их командой prepared и завершите командой recover\n", p.Cluster.Name, slots.Used)
+		}
+		value := max(defaultMaxPreparedTransactions, slots.Used+need)
+		if p.Cluster.managed() != nil {
+			return newTransferError(phasePreflight, p.Cluster.Name,
+				fmt.Errorf("%s; установите max_prepared_transactions = %d и перезапустите сервер", problem, value))
+		}
+		question := fmt.Sprintf("На сервере %s %s. Установить max_prepared_transactions = %d и перезапустить сервер?", p.Cluster.Name, problem, value)
+		if !opts.ConfigurePrepared && (opts.confirm == nil || !opts.confirm(question)) {
+			return newTransferError(phasePreflight, p.Cluster.Name,
+				fmt.Errorf("%s; выполните config set -name max_prepared_transactions -value %d или передачу с флагом -configure-prepared", problem, value))
+		}
+		setting, err := setServerParameter(p.Cluster, p.Server, "max_prepared_transactions", strconv.Itoa(value), false, true)
+		if err != nil {
+			return newTransferError(phasePreflight, p.Cluster.Name, err)
+		}
+		fmt.Printf("Сервер %s: %s\n", p.Cluster.Name, setting)
+		if slots, err = readPreparedSlots(p.Server); err != nil {
+			return newTransferError(phasePreflight, p.Cluster.Name, err)
+		}
+		if slots.free() < need {
+			return newTransferError(phasePreflight, p.Cluster.Name,
+				fmt.Errorf("после настройки свободных мест для подготовленных транзакций нет (max_prepared_transactions = %d, занято %d)", slots.Max, slots.Used))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"github.com/lib/pq"
+	"time"
+)
+
+// Возраст записи prepared без решения, после которого recover считает передачу брошенной,
+// если проверить её процесс нельзя
+const defaultRecoverMinAge = 10 * time.Minute
+
+// Подготовленная транзакция на сервере (строка pg_prepared_xacts)
+type preparedXact struct {
+	GID      string
+	Database string
+	Owner    string
+	Prepared time.Time
+}
+
+// Список подготовленных транзакций сервера. server - строка подключения, в сообщения
+// об ошибках она не включается
+func listPreparedXacts(server string) ([]preparedXact, error) {
+	db, err := openDB(server, "postgres")
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка подключения к серверу: %v", err)
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SELECT gid, database, owner, prepared FROM pg_prepared_xacts ORDER BY prepared")
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка чтения pg_prepared_xacts: %v", err)
+	}
+	defer rows.Close()
+
+	var xacts []preparedXact
+	for rows.Next() {
+		var x preparedXact
+		if err := rows.Scan(&x.GID, &x.Database, &x.Owner, &x.Prepared); err != nil {
+			return nil, fmt.Errorf("Ошибка чтения pg_prepared_xacts: %v", err)
+		}
+		xacts = append(xacts, x)
+	}
+	return xacts, rows.Err()
+}
+
+// Завершение подготовленной транзакции. COMMIT/ROLLBACK PREPARED выполняется в той же БД,
+// в которой транзакция была подготовлена
+func finishPrepared(server, database, gid string, commit bool) error {
+	db, err := openDB(server, database)
+	if err != nil {
+		return fmt.Errorf("Ошибка подключения к серверу: %v", err)
+	}
+	defer db.Close()
+
+	command := "ROLLBACK PREPARED "
+	if commit {
+		command = "COMMIT PREPARED "
+	}
+	if _, err := db.Exec(command + pq.QuoteLiteral(gid)); err != nil {
+		return fmt.Errorf("Ошибка выполнения %s%s: %v", command, gid, err)
+	}
+	return nil
+}
+
+// Восстановление транзакций в неопределённом состоянии на всех кластерах инвентаря.
+// Транзакции с записанным решением COMMIT фиксируются, остальные известные координатору
+// откатываются (презумпция отката). Транзакция без решения откатывается, только если
+// подготовившая её передача не работает: процесс завершился или запись старше minAge.
+// Транзакции, которых нет в журнале, не трогаются. Пока журнал заблокирован идущей
+// передачей, восстановление не выполняется
+func recoverInDoubt(inv *Inventory, coord *coordinatorLog, dryRun bool, minAge time.Duration) error {
+	unlock, err := coord.lock(false)
+	if err != nil {
+		if !dryRun {
+			return fmt.Errorf("Восстановление невозможно: %v", err)
+		}
+		fmt.Printf("%v, восстановление сейчас невозможно\n", err)
+	} else {
+		defer unlock()
+	}
+
+	outcomes, err := coord.outcomes()
+	if err != nil {
+		return err
+	}
+
+	var failed int
+	var committed, rolledBack, skipped int
+	for _, c := range inv.Clusters {
+		server := c.connParams().conninfo()
+		xacts, err := listPreparedXacts(server)
+		if err != nil {
+			fmt.Printf("Сервер %s пропущен: %v\n", c.Name, err)
+			failed++
+			continue
+		}
+		for _, x := range xacts {
+			outcome, known := outcomes[x.GID]
+			if !known {
+				fmt.Printf("Сервер %s: транзакция %s (БД %s, %s) не найдена в журнале координатора, пропускаем\n",
+					c.Name, x.GID, x.Database, describeGID(x.GID))
+				skipped++
+				continue
+			}
+			if outcome.Decision == "" && !outcome.abandoned(time.Now(), minAge) {
+				fmt.Printf("Сервер %s: транзакция %s (БД %s, запуск %s) без решения, передача могла не завершиться, пропускаем\n",
+					c.Name, x.GID, x.Database, outcome.RunID)
+				skipped++
+				continue
+			}
+			commit := outcome.Decision == coordCommit
+			action := "ROLLBACK PREPARED"
+			reason := "решение об откате"
+			if commit {
+				action = "COMMIT PREPARED"
+				reason = "решение о фиксации"
+			} else if outcome.Decision == "" {
+				reason = "решение не записано, презумпция отката"
+			}
+			fmt.Printf("Сервер %s: %s %s (БД %s, запуск %s, подготовлена %s): %s\n",
+				c.Name, action, x.GID, x.Database, outcome.RunID, x.Prepared.Format("2006-01-02 15:04:05"), reason)
+			if dryRun {
+				continue
+			}
+			if outcome.Decision == "" {
+				// Решение об откате записывается до отката, чтобы журнал не противоречил серверам
+				if err := coord.append(outcome.RunID, coordAbort, []participantGID{outcome.Participant}); err != nil {
+					return err
+				}
+			}
+			if err := finishPrepared(server, x.Database, x.GID, commit); err != nil {
+				fmt.Printf("Сервер %s: %v\n", c.Name, err)
+				failed++
+				continue
+			}
+			if err := coord.append(outcome.RunID, coordDone, []participantGID{outcome.Participant}); err != nil {
+				return err
+			}
+			if commit {
+				committed++
+			} else {
+				rolledBack++
+			}
+		}
+	}
+
+	fmt.Printf("Восстановление завершено: зафиксировано %d, откачено %d, пропущено %d\n", committed, rolledBack, skipped)
+	if failed > 0 {
+		return fmt.Errorf("Восстановление выполнено не полностью: ошибок %d", failed)
+	}
+	return nil
+}
+
+// Вывод подготовленных транзакций на кластерах инвентаря с привязкой к запускам передачи.
+// runID - показать только транзакции указанного запуска (пустая строка - все)
+func listPrepared(inv *Inventory, coord *coordinatorLog, runID string) error {
+	outcomes, err := coord.outcomes()
+	if err != nil {
+		return err
+	}
+	var failed int
+	for _, c := range inv.Clusters {
+		xacts, err := listPreparedXacts(c.connParams().conninfo())
+		if err != nil {
+			fmt.Printf("Сервер %s пропущен: %v\n", c.Name, err)
+			failed++
+			continue
+		}
+		fmt.Printf("Сервер %s:\n", c.Name)
+		shown := 0
+		for _, x := range xacts {
+			outcome, known := outcomes[x.GID]
+			xRunID := outcome.RunID
+			if info, ok := parseGID(x.GID); ok && xRunID == "" {
+				xRunID = info.RunID
+			}
+			if runID != "" && xRunID != runID {
+				continue
+			}
+			state := "нет в журнале координатора"
+			if known {
+				state = outcome.String()
+			}
+			fmt.Printf("  %s (БД %s, владелец %s, подготовлена %s): %s; %s\n",
+				x.GID, x.Database, x.Owner, x.Prepared.Format("2006-01-02 15:04:05"), describeGID(x.GID), state)
+			shown++
+		}
+		if shown == 0 {
+			fmt.Println("  подготовленных транзакций нет")
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("Не удалось опросить серверов: %d", failed)
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/lib/pq"
+)
+
+// Последовательность, принадлежащая столбцу таблицы на сервере. Пустая строка - её нет
+func columnSequence(tx *sql.Tx, t *tableInfo, column string) (string, error) {
+	var seq sql.NullString
+	if err := tx.QueryRow("SELECT pg_get_serial_sequence($1, $2)", t.qualified(), column).Scan(&seq); err != nil {
+		return "", fmt.Errorf("Ошибка поиска последовательности столбца %s.%s: %v", t, column, err)
+	}
+	return seq.String, nil
+}
+
+// Максимальное значение столбца. false - в таблице нет строк
+func columnMax(tx *sql.Tx, t *tableInfo, column string) (int64, bool, error) {
+	var value sql.NullInt64
+	query := fmt.Sprintf("SELECT max(%s)::bigint FROM %s", pq.QuoteIdentifier(column), t.qualified())
+	if err := tx.QueryRow(query).Scan(&value); err != nil {
+		return 0, false, fmt.Errorf("Ошибка чтения максимального значения %s.%s: %v", t, column, err)
+	}
+	return value.Int64, value.Valid, nil
+}
+
+// Продвижение последовательностей приёмника до максимального значения переданных столбцов,
+// чтобы следующий обычный INSERT не столкнулся с переданными строками. Последовательность
+// не сдвигается назад. setval не откатывается вместе с транзакцией, поэтому при откате
+// передачи на приёмнике остаётся только пропуск номеров. server - имя сервера для сообщений
+func advanceSequences(txB *sql.Tx, tables []*tableInfo, server string) error {
+	for _, t := range tables {
+		for _, c := range t.dataColumns() {
+			if c.OwnedSequence == "" {
+				continue
+			}
+			seq, err := columnSequence(txB, t, c.Name)
+			if err != nil {
+				return err
+			}
+			if seq == "" {
+				continue
+			}
+			maxValue, found, err := columnMax(txB, t, c.Name)
+			if err != nil {
+				return err
+			}
+			if !found {
+				continue
+			}
+			// greatest пропускает NULL неиспользованной последовательности. Значение ниже
+			// минимального (пустые или отрицательные ключи) не устанавливается: последовательность
+			// начинается с минимального значения
+			var value int64
+			if err := txB.QueryRow(`SELECT CASE WHEN v.target >= s.seqmin THEN setval(s.seqrelid, v.target)
+				ELSE setval(s.seqrelid, s.seqmin, false) END
+				FROM pg_sequence s, LATERAL (SELECT greatest($2::bigint, pg_sequence_last_value(s.seqrelid)) AS target) v
+				WHERE s.seqrelid = $1::regclass`, seq, maxValue).Scan(&value); err != nil {
+				return fmt.Errorf("Ошибка продвижения последовательности %s на сервере %s: %v", seq, server, err)
+			}
+			fmt.Printf("Последовательность %s на сервере %s продвинута до %d\n", seq, server, value)
+		}
+	}
+	return nil
+}
+
+// Сброс последовательностей источника после фиксации переноса: до максимального оставшегося
+// значения или к началу, если таблица опустела. setval не откатывается вместе с транзакцией,
+// поэтому сброс выполняется только после COMMIT PREPARED, в отдельной транзакции на каждую
+// таблицу. Таблица блокируется от изменений, чтобы максимум учитывал строки, вставленные
+// после снимка передачи. server - имя сервера для сообщений
+func resetSequences(db *sql.DB, tables []*tableInfo, server string) error {
+	for _, t := range tables {
+		if err := resetTableSequences(db, t, server); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Сброс последовательностей столбцов одной таблицы в отдельной транзакции
+func resetTableSequences(db *sql.DB, t *tableInfo, server string) error {
+	var columns []columnInfo
+	for _, c := range t.dataColumns() {
+		if c.OwnedSequence != "" {
+			columns = append(columns, c)
+		}
+	}
+	if len(columns) == 0 {
+		return nil
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("Ошибка начала транзакции на сервере %s: %v", server, err)
+	}
+	defer tx.Rollback()
+	// SHARE ждёт незафиксированные изменения таблицы и запрещает новые до конца транзакции
+	if _, err := tx.Exec("LOCK TABLE " + t.qualified() + " IN SHARE MODE"); err != nil {
+		return fmt.Errorf("Ошибка блокировки таблицы %s на сервере %s: %v", t, server, err)
+	}
+	for _, c := range columns {
+		maxValue, found, err := columnMax(tx, t, c.Name)
+		if err != nil {
+			return err
+		}
+		var value int64
+		if found {
+			err = tx.QueryRow("SELECT setval($1::regclass, $2)", c.OwnedSequence, maxValue).Scan(&value)
+		} else {
+			err = tx.QueryRow("SELECT setval($1::regclass, s.seqstart, false) FROM pg_sequence s WHERE s.seqrelid = $1::regclass",
+				c.OwnedSequence).Scan(&value)
+		}
+		if err != nil {
+			return fmt.Errorf("Ошибка сброса последовательности %s на сервере %s: %v", c.OwnedSequence, server, err)
+		}
+		fmt.Printf("Последовательность %s на сервере %s сброшена до %d\n", c.OwnedSequence, server, value)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("Ошибка фиксации сброса последовательностей на сервере %s: %v", server, err)
+	}
+	return nil
+}
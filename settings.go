@@ -0,0 +1,210 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/lib/pq"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// БД для подключения при изменении параметров сервера
+const settingsDatabase = "postgres"
+
+// Время ожидания, пока сервер перечитает конфигурацию после pg_reload_conf
+const reloadWait = 3 * time.Second
+
+// Допустимое имя параметра в файле конфигурации (с точкой - параметр расширения)
+var parameterName = regexp.MustCompile(`^[a-z_][a-z0-9_.]*$`)
+
+// Параметр сервера из pg_settings
+type serverSetting struct {
+	Name           string
+	Setting        string // Значение в единицах Unit
+	Unit           string
+	Context        string // Когда изменение вступает в силу: postmaster, sighup, user и др.
+	Source         string // Откуда взято значение: default, configuration file и др.
+	PendingRestart bool   // Значение изменено в файлах конфигурации, но вступит в силу после перезапуска
+}
+
+func (s serverSetting) String() string {
+	value := s.Setting
+	if s.Unit != "" {
+		value += " (" + s.Unit + ")"
+	}
+	msg := fmt.Sprintf("%s = %s, источник: %s, применение: %s", s.Name, value, s.Source, s.applyMethod())
+	if s.PendingRestart {
+		msg += ", ожидает перезапуска"
+	}
+	return msg
+}
+
+// Способ применения изменения параметра по его контексту в pg_settings
+func (s serverSetting) applyMethod() string {
+	switch s.Context {
+	case "internal":
+		return "не изменяется"
+	case "postmaster":
+		return "перезапуск"
+	case "backend", "superuser-backend":
+		return "перечитывание конфигурации, для новых сеансов"
+	}
+	return "перечитывание конфигурации"
+}
+
+// Чтение параметра сервера из pg_settings
+func readSetting(db *sql.DB, name string) (serverSetting, error) {
+	s := serverSetting{}
+	err := db.QueryRow(`SELECT name, setting, COALESCE(unit, ''), context, source, pending_restart
+		FROM pg_settings WHERE name = lower($1)`, name).
+		Scan(&s.Name, &s.Setting, &s.Unit, &s.Context, &s.Source, &s.PendingRestart)
+	if errors.Is(err, sql.ErrNoRows) {
+		return s, fmt.Errorf("Неизвестный параметр сервера %s", name)
+	}
+	if err != nil {
+		return s, fmt.Errorf("Ошибка чтения параметра %s: %v", name, err)
+	}
+	return s, nil
+}
+
+// Путь к postgresql.auto.conf кластера, в который пишет ALTER SYSTEM
+func (c Cluster) autoConfFile() string {
+	return filepath.Join(c.DataDir, "postgresql.auto.conf")
+}
+
+// Ожидание, пока сервер перечитает конфигурацию: значение параметра изменится или
+// появится признак ожидания перезапуска. Возвращает последнее прочитанное значение
+func waitForReload(db *sql.DB, before serverSetting) (serverSetting, error) {
+	deadline := time.Now().Add(reloadWait)
+	for {
+		after, err := readSetting(db, before.Name)
+		if err != nil || after.Setting != before.Setting || after.Source != before.Source ||
+			after.PendingRestart || time.Now().After(deadline) {
+			return after, err
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// Изменение параметра сервера командой ALTER SYSTEM (reset - возврат к значению из
+// postgresql.conf) и его применение: перечитывание конфигурации или, если параметр требует
+// перезапуска и это разрешено, перезапуск управляемого кластера. server - строка подключения
+// (пусто - параметры кластера из инвентаря). Если сервер не запущен, параметр записывается
+// в postgresql.auto.conf и применяется при запуске
+func setServerParameter(cluster Cluster, server, name, value string, reset, allowRestart bool) (serverSetting, error) {
+	if server == "" {
+		server = cluster.connParams().conninfo()
+	}
+	if cluster.DataDir != "" {
+		status, err := getClusterStatus(cluster)
+		if err != nil {
+			return serverSetting{}, err
+		}
+		if status.State == StateNotACluster {
+			return serverSetting{}, fmt.Errorf("Невозможно изменить параметр: по пути %s нет кластера", cluster.DataDir)
+		}
+		if !status.State.alive() {
+			return serverSetting{}, setOfflineParameter(cluster, name, value, reset)
+		}
+	}
+
+	db, err := openDB(server, settingsDatabase)
+	if err != nil {
+		return serverSetting{}, fmt.Errorf("Ошибка подключения к серверу %s: %v", cluster.Name, err)
+	}
+	defer db.Close()
+	before, err := readSetting(db, name)
+	if err != nil {
+		return serverSetting{}, err
+	}
+	if before.Context == "internal" {
+		return before, fmt.Errorf("Параметр %s не может быть изменён", before.Name)
+	}
+	query := fmt.Sprintf("ALTER SYSTEM SET %s = %s", pq.QuoteIdentifier(before.Name), pq.QuoteLiteral(value))
+	if reset {
+		query = "ALTER SYSTEM RESET " + pq.QuoteIdentifier(before.Name)
+	}
+	if _, err := db.Exec(query); err != nil {
+		return before, fmt.Errorf("Ошибка изменения параметра %s на сервере %s: %v", before.Name, cluster.Name, err)
+	}
+	if _, err := db.Exec("SELECT pg_reload_conf()"); err != nil {
+		return before, fmt.Errorf("Ошибка перечитывания конфигурации сервера %s: %v", cluster.Name, err)
+	}
+	after, err := waitForReload(db, before)
+	if err != nil || !after.PendingRestart {
+		return after, err
+	}
+
+	if !allowRestart || cluster.DataDir == "" {
+		fmt.Printf("Параметр %s сервера %s вступит в силу после перезапуска\n", after.Name, cluster.Name)
+		return after, nil
+	}
+	db.Close()
+	fmt.Printf("Параметр %s требует перезапуска, перезапускаем сервер %s\n", after.Name, cluster.Name)
+	if err := restartCluster(cluster); err != nil {
+		return after, err
+	}
+	if db, err = openDB(server, settingsDatabase); err != nil {
+		return after, fmt.Errorf("Ошибка подключения к серверу %s: %v", cluster.Name, err)
+	}
+	defer db.Close()
+	return readSetting(db, name)
+}
+
+// Изменение параметра остановленного кластера в postgresql.auto.conf. Сервер не проверяет
+// имя, поэтому оно проверяется до записи, чтобы не испортить файл конфигурации
+func setOfflineParameter(cluster Cluster, name, value string, reset bool) error {
+	name = strings.ToLower(name)
+	if !parameterName.MatchString(name) {
+		return fmt.Errorf("Недопустимое имя параметра сервера %q", name)
+	}
+	if reset {
+		if err := removeConfParameter(cluster.autoConfFile(), name); err != nil {
+			return err
+		}
+		fmt.Printf("Сервер %s не запущен: параметр %s удалён из %s, изменение будет применено при запуске\n", cluster.Name, name, cluster.autoConfFile())
+		return nil
+	}
+	if err := writeConfParameter(cluster.autoConfFile(), name, value, ""); err != nil {
+		return err
+	}
+	fmt.Printf("Сервер %s не запущен: параметр %s записан в %s и будет применён при запуске\n", cluster.Name, name, cluster.autoConfFile())
+	return nil
+}
+
+// Значение параметра кластера: из pg_settings работающего сервера или, если он не запущен,
+// из postgresql.auto.conf и postgresql.conf
+func showServerParameter(cluster Cluster, name string) error {
+	if cluster.DataDir != "" {
+		if status, err := getClusterStatus(cluster); err != nil {
+			return err
+		} else if !status.State.alive() {
+			for _, path := range []string{cluster.autoConfFile(), cluster.confFile()} {
+				entry, found, err := readConfEntry(path, name)
+				if err != nil {
+					return fmt.Errorf("Ошибка чтения %s: %v", path, err)
+				}
+				if found {
+					fmt.Printf("Сервер %s не запущен: %s = %s (%s)\n", cluster.Name, entry.Name, entry.Value, path)
+					return nil
+				}
+			}
+			fmt.Printf("Сервер %s не запущен: параметр %s не задан в файлах конфигурации\n", cluster.Name, name)
+			return nil
+		}
+	}
+	db, err := openDB(cluster.connParams().conninfo(), settingsDatabase)
+	if err != nil {
+		return fmt.Errorf("Ошибка подключения к серверу %s: %v", cluster.Name, err)
+	}
+	defer db.Close()
+	s, err := readSetting(db, name)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Сервер %s: %s\n", cluster.Name, s)
+	return nil
+}
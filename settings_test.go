@@ -0,0 +1,34 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestSetOfflineParameterName(t *testing.T) {
+	tests := []struct {
+		name string
+		ok   bool
+	}{
+		{"max_prepared_transactions", true},
+		{"Work_Mem", true},
+		{"pg_stat_statements.max", true},
+		{"_private", true},
+		{"", false},
+		{"1port", false},
+		{"port = 1\nlisten_addresses", false},
+		{"port#", false},
+		{"work mem", false},
+		{"search-path", false},
+	}
+	for _, tt := range tests {
+		cluster := Cluster{Name: "test", DataDir: t.TempDir()}
+		err := setOfflineParameter(cluster, tt.name, "1", false)
+		if (err == nil) != tt.ok {
+			t.Errorf("setOfflineParameter(%q) error = %v, want ok %v", tt.name, err, tt.ok)
+		}
+		if _, statErr := os.Stat(cluster.autoConfFile()); !tt.ok && statErr == nil {
+			t.Errorf("setOfflineParameter(%q) wrote %s", tt.name, cluster.autoConfFile())
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// Режимы SSL libpq. lib/pq поддерживает только disable, require, verify-ca и verify-full,
+// режимы allow и prefer выполняются программой повторным подключением
+const (
+	sslDisable    = "disable"     // Без SSL
+	sslAllow      = "allow"       // Без SSL, а если сервер его требует - с SSL
+	sslPrefer     = "prefer"      // С SSL, а если сервер его не поддерживает - без SSL
+	sslRequire    = "require"     // Только SSL без проверки сертификата сервера
+	sslVerifyCA   = "verify-ca"   // SSL с проверкой сертификата сервера по корневому сертификату
+	sslVerifyFull = "verify-full" // Как verify-ca, и имя хоста должно совпадать с сертификатом
+)
+
+// Допустимые режимы SSL
+var sslModes = []string{sslDisable, sslAllow, sslPrefer, sslRequire, sslVerifyCA, sslVerifyFull}
+
+// Режим SSL с учётом прежних значений y/n. Пустое значение - disable
+func normalizeSSLMode(mode string) string {
+	switch strings.ToLower(strings.TrimSpace(mode)) {
+	case "", "n", "no":
+		return sslDisable
+	case "y", "yes":
+		return sslRequire
+	}
+	return strings.ToLower(strings.TrimSpace(mode))
+}
+
+// Корневой сертификат по умолчанию, как у libpq: ~/.postgresql/root.crt
+// (%APPDATA%\postgresql\root.crt в Windows). Пустая строка - файла нет
+func defaultRootCert() string {
+	dir := ""
+	if runtime.GOOS == "windows" {
+		dir = filepath.Join(os.Getenv("APPDATA"), "postgresql")
+	} else if home, err := os.UserHomeDir(); err == nil {
+		dir = filepath.Join(home, ".postgresql")
+	}
+	path := filepath.Join(dir, "root.crt")
+	if _, err := os.Stat(path); dir == "" || err != nil {
+		return ""
+	}
+	return path
+}
+
+// Проверка режима SSL
+func checkSSLMode(mode string) error {
+	for _, m := range sslModes {
+		if m == mode {
+			return nil
+		}
+	}
+	return fmt.Errorf("Неизвестный режим SSL %q (допустимо: %s)", mode, strings.Join(sslModes, ", "))
+}
+
+// Проверка параметров SSL до подключения: режим, наличие файлов сертификатов и ключа
+func validateSSL(mode, rootCert, cert, key string) error {
+	if err := checkSSLMode(mode); err != nil {
+		return err
+	}
+	if mode == sslDisable {
+		// Сертификаты без SSL не используются
+		return nil
+	}
+	if (mode == sslVerifyCA || mode == sslVerifyFull) && rootCert == "" {
+		return fmt.Errorf("Для режима SSL %s нужен корневой сертификат sslrootcert", mode)
+	}
+	if (cert == "") != (key == "") {
+		return fmt.Errorf("Сертификат клиента sslcert и ключ sslkey задаются вместе")
+	}
+	for _, file := range []struct{ name, path string }{{"sslrootcert", rootCert}, {"sslcert", cert}, {"sslkey", key}} {
+		if file.path == "" {
+			continue
+		}
+		info, err := os.Stat(file.path)
+		if err != nil {
+			return fmt.Errorf("Файл %s %s недоступен: %v", file.name, file.path, err)
+		}
+		if info.IsDir() {
+			return fmt.Errorf("Файл %s %s является каталогом", file.name, file.path)
+		}
+		// lib/pq, как и libpq, отказывается от ключа, доступного группе или остальным
+		if file.name == "sslkey" && runtime.GOOS != "windows" && info.Mode().Perm()&0o077 != 0 {
+			return fmt.Errorf("Ключ sslkey %s доступен группе или остальным пользователям (нужны права 0600)", file.path)
+		}
+	}
+	return nil
+}
+
+// Параметры SSL с режимом в каноническом виде и корневым сертификатом по умолчанию
+// для режимов с проверкой сертификата сервера
+func (p connParams) withSSLDefaults() connParams {
+	p.SSLMode = normalizeSSLMode(p.SSLMode)
+	if p.SSLRootCert == "" && (p.SSLMode == sslVerifyCA || p.SSLMode == sslVerifyFull) {
+		p.SSLRootCert = defaultRootCert()
+	}
+	return p
+}
+
+// Проверка параметров SSL подключения
+func (p connParams) validate() error {
+	p = p.withSSLDefaults()
+	return validateSSL(p.SSLMode, p.SSLRootCert, p.SSLCert, p.SSLKey)
+}
+
+// Формирование строки подключения из разобранных параметров
+func formatConninfo(params map[string]string) string {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = k + "=" + conninfoValue(params[k])
+	}
+	return strings.Join(parts, " ")
+}
+
+// Подключение в режиме allow или prefer: сначала без SSL (allow) или с SSL (prefer),
+// при ошибке - в другом режиме. Подключение проверяется сразу, чтобы выбрать режим
+func openWithSSLFallback(params map[string]string) (*sql.DB, error) {
+	modes := []string{sslRequire, sslDisable}
+	if params["sslmode"] == sslAllow {
+		modes = []string{sslDisable, sslRequire}
+	}
+	var failures []string
+	for _, mode := range modes {
+		params["sslmode"] = mode
+		db, err := sql.Open("postgres", formatConninfo(params))
+		if err == nil {
+			if err = db.Ping(); err == nil {
+				return db, nil
+			}
+			db.Close()
+		}
+		failures = append(failures, fmt.Sprintf("sslmode=%s: %v", mode, err))
+	}
+	return nil, fmt.Errorf("Ошибка подключения: %s", strings.Join(failures, "; "))
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Коды завершения pg_ctl status (см. документацию pg_ctl)
+const (
+	pgCtlStatusRunning    = 0 // Сервер запущен
+	pgCtlStatusNotRunning = 3 // Сервер не запущен
+	pgCtlStatusNoDataDir  = 4 // Каталог данных недоступен
+)
+
+// Состояние кластера
+type ClusterState int
+
+const (
+	StateStopped     ClusterState = iota // Сервер остановлен
+	StateStarting                        // Сервер запускается и ещё не принимает подключения
+	StateRunning                         // Сервер запущен и принимает подключения
+	StateStopping                        // Сервер останавливается
+	StateStalePid                        // Процесс не работает, но остался postmaster.pid
+	StateNotACluster                     // По пути нет кластера PostgreSQL
+)
+
+func (s ClusterState) String() string {
+	switch s {
+	case StateStopped:
+		return "остановлен"
+	case StateStarting:
+		return "запускается"
+	case StateRunning:
+		return "запущен"
+	case StateStopping:
+		return "останавливается"
+	case StateStalePid:
+		return "не работает, остался postmaster.pid"
+	case StateNotACluster:
+		return "не является кластером"
+	}
+	return fmt.Sprintf("неизвестное состояние %d", int(s))
+}
+
+// Процесс сервера существует (запущен, запускается или останавливается)
+func (s ClusterState) alive() bool {
+	return s == StateStarting || s == StateRunning || s == StateStopping
+}
+
+// Статус кластера по данным pg_ctl и postmaster.pid
+type ClusterStatus struct {
+	DataDir    string
+	State      ClusterState
+	PID        int       // PID postmaster
+	Port       int       // Порт, на котором слушает сервер
+	SocketDir  string    // Каталог Unix-сокета (пустой, если сокеты не используются)
+	ListenAddr string    // Первый адрес из listen_addresses
+	StartTime  time.Time // Время запуска сервера
+	PidStatus  string    // Строка статуса из postmaster.pid (starting, ready, stopping, standby)
+}
+
+// Содержимое postmaster.pid. Формат строк описан в src/include/miscadmin.h
+type postmasterPid struct {
+	PID        int
+	DataDir    string
+	StartTime  time.Time
+	Port       int
+	SocketDir  string
+	ListenAddr string
+	Status     string
+}
+
+// Разбор файла postmaster.pid в каталоге данных. clusterPath - путь к кластеру
+func readPostmasterPid(clusterPath string) (*postmasterPid, error) {
+	f, err := os.Open(filepath.Join(clusterPath, "postmaster.pid"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, strings.TrimSpace(scanner.Text()))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 {
+		return nil, fmt.Errorf("файл postmaster.pid пуст")
+	}
+
+	pid := &postmasterPid{}
+	if pid.PID, err = strconv.Atoi(lines[0]); err != nil {
+		return nil, fmt.Errorf("некорректный PID в postmaster.pid: %q", lines[0])
+	}
+	// Остальные строки могут отсутствовать, если сервер ещё не дописал файл
+	line := func(n int) string {
+		if n < len(lines) {
+			return lines[n]
+		}
+		return ""
+	}
+	pid.DataDir = line(1)
+	if sec, err := strconv.ParseInt(line(2), 10, 64); err == nil {
+		pid.StartTime = time.Unix(sec, 0)
+	}
+	pid.Port, _ = strconv.Atoi(line(3))
+	pid.SocketDir = line(4)
+	pid.ListenAddr = line(5)
+	pid.Status = line(7)
+	return pid, nil
+}
+
+// Проверка, что каталог содержит кластер PostgreSQL
+func isClusterDir(clusterPath string) bool {
+	info, err := os.Stat(filepath.Join(clusterPath, "PG_VERSION"))
+	return err == nil && !info.IsDir()
+}
+
+// Получение статуса кластера. cluster - описание кластера из инвентаря
+func getClusterStatus(cluster Cluster) (ClusterStatus, error) {
+	clusterPath := cluster.DataDir
+	status := ClusterStatus{DataDir: clusterPath, State: StateNotACluster}
+	if !ClusterExsists(clusterPath) || !isClusterDir(clusterPath) {
+		return status, nil
+	}
+
+	pid, pidErr := readPostmasterPid(clusterPath)
+	if pidErr != nil && !errors.Is(pidErr, os.ErrNotExist) {
+		return status, fmt.Errorf("Ошибка чтения postmaster.pid сервера %s: %v", clusterPath, pidErr)
+	}
+	if pid != nil {
+		status.PID = pid.PID
+		status.Port = pid.Port
+		status.SocketDir = pid.SocketDir
+		status.ListenAddr = pid.ListenAddr
+		status.StartTime = pid.StartTime
+		status.PidStatus = pid.Status
+	}
+
+	cmd, err := cluster.pgCommand("pg_ctl", "-D", clusterPath, "status")
+	if err != nil {
+		return status, err
+	}
+	output, err := cmd.CombinedOutput()
+	code := 0
+	if err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			return status, fmt.Errorf("Ошибка при запуске pg_ctl: %v", err)
+		}
+		code = exitErr.ExitCode()
+	}
+
+	switch code {
+	case pgCtlStatusRunning:
+		status.State = StateRunning
+		switch status.PidStatus {
+		case "starting":
+			status.State = StateStarting
+		case "stopping":
+			status.State = StateStopping
+		}
+	case pgCtlStatusNotRunning:
+		status.State = StateStopped
+		if pid != nil {
+			status.State = StateStalePid
+		}
+	case pgCtlStatusNoDataDir:
+		status.State = StateNotACluster
+	default:
+		decodedOutput, decodeErr := decodeOutput(output)
+		if decodeErr != nil {
+			decodedOutput = string(output)
+		}
+		return status, fmt.Errorf("Ошибка при проверке статуса сервера: %v, вывод: %s", err, decodedOutput)
+	}
+	return status, nil
+}
+
+// Описание статуса для вывода пользователю
+func (s ClusterStatus) String() string {
+	var b strings.Builder
+	b.WriteString(s.State.String())
+	if s.PID != 0 && s.State != StateStopped {
+		fmt.Fprintf(&b, " (PID %d", s.PID)
+		if s.Port != 0 {
+			fmt.Fprintf(&b, ", порт %d", s.Port)
+		}
+		if s.SocketDir != "" {
+			fmt.Fprintf(&b, ", сокет %s", s.SocketDir)
+		}
+		if !s.StartTime.IsZero() && s.State.alive() {
+			fmt.Fprintf(&b, ", запущен %s", s.StartTime.Format("2006-01-02 15:04:05"))
+		}
+		b.WriteString(")")
+	}
+	return b.String()
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"github.com/lib/pq"
+	"strings"
+)
+
+// Общий интерфейс *sql.DB и *sql.Tx для запросов
+type queryer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// Описание столбца таблицы
+type columnInfo struct {
+	Name          string
+	Type          string // Тип в формате format_type, например numeric(10,2) или integer[]
+	NotNull       bool
+	Default       string // Выражение по умолчанию
+	Identity      string // ALWAYS или BY DEFAULT для столбцов идентификации
+	Generated     string // Выражение вычисляемого столбца
+	OwnedSequence string // Последовательность, принадлежащая столбцу (SERIAL или идентификация)
+}
+
+// Столбец SERIAL: значение по умолчанию берётся из собственной последовательности
+func (c columnInfo) serial() bool {
+	return c.OwnedSequence != "" && c.Identity == "" && strings.HasPrefix(c.Default, "nextval(")
+}
+
+// Описание таблицы
+type tableInfo struct {
+	Schema     string
+	Name       string
+	Columns    []columnInfo
+	PrimaryKey []string
+	References []string // Передаваемые таблицы, на которые ссылается эта таблица
+}
+
+// Имя таблицы с экранированием для SQL
+func (t *tableInfo) qualified() string {
+	return pq.QuoteIdentifier(t.Schema) + "." + pq.QuoteIdentifier(t.Name)
+}
+
+// Имя таблицы для вывода
+func (t *tableInfo) String() string {
+	return t.Schema + "." + t.Name
+}
+
+// Столбцы, значения которых передаются (вычисляемые столбцы пересчитываются на приёмнике)
+func (t *tableInfo) dataColumns() []columnInfo {
+	var cols []columnInfo
+	for _, c := range t.Columns {
+		if c.Generated == "" {
+			cols = append(cols, c)
+		}
+	}
+	return cols
+}
+
+// Разбор имени таблицы вида schema.table. Без схемы используется public
+func splitTableName(name string) (schema, table string) {
+	if schema, table, found := strings.Cut(name, "."); found {
+		return schema, table
+	}
+	return "public", name
+}
+
+// Получение описания таблицы из information_schema. Возвращает nil, если таблицы нет
+func introspectTable(q queryer, schema, name string) (*tableInfo, error) {
+	rows, err := q.Query(`
+		SELECT c.column_name,
+		       format_type(a.atttypid, a.atttypmod),
+		       c.is_nullable = 'NO',
+		       coalesce(c.column_default, ''),
+		       coalesce(c.identity_generation, ''),
+		       coalesce(c.generation_expression, ''),
+		       coalesce(pg_get_serial_sequence(format('%I.%I', c.table_schema, c.table_name), c.column_name), '')
+		FROM information_schema.columns c
+		JOIN pg_attribute a
+		  ON a.attrelid = format('%I.%I', c.table_schema, c.table_name)::regclass
+		 AND a.attname = c.column_name
+		WHERE c.table_schema = $1 AND c.table_name = $2
+		ORDER BY c.ordinal_position`, schema, name)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка чтения столбцов таблицы %s.%s: %v", schema, name, err)
+	}
+	defer rows.Close()
+
+	t := &tableInfo{Schema: schema, Name: name}
+	for rows.Next() {
+		var c columnInfo
+		if err := rows.Scan(&c.Name, &c.Type, &c.NotNull, &c.Default, &c.Identity, &c.Generated, &c.OwnedSequence); err != nil {
+			return nil, fmt.Errorf("Ошибка чтения столбцов таблицы %s: %v", t, err)
+		}
+		t.Columns = append(t.Columns, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("Ошибка чтения столбцов таблицы %s: %v", t, err)
+	}
+	if len(t.Columns) == 0 {
+		return nil, nil
+	}
+
+	pkRows, err := q.Query(`
+		SELECT kcu.column_name
+		FROM information_schema.table_constraints tc
+		JOIN information_schema.key_column_usage kcu
+		  ON kcu.constraint_schema = tc.constraint_schema
+		 AND kcu.constraint_name = tc.constraint_name
+		 AND kcu.table_name = tc.table_name
+		WHERE tc.table_schema = $1 AND tc.table_name = $2 AND tc.constraint_type = 'PRIMARY KEY'
+		ORDER BY kcu.ordinal_position`, schema, name)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка чтения первичного ключа таблицы %s: %v", t, err)
+	}
+	defer pkRows.Close()
+	for pkRows.Next() {
+		var col string
+		if err := pkRows.Scan(&col); err != nil {
+			return nil, fmt.Errorf("Ошибка чтения первичного ключа таблицы %s: %v", t, err)
+		}
+		t.PrimaryKey = append(t.PrimaryKey, col)
+	}
+	return t, pkRows.Err()
+}
+
+// Проверка, что столбец сам по себе уникален: на нём одном построен первичный ключ или
+// ограничение UNIQUE
+func uniqueColumn(q queryer, t *tableInfo, column string) (bool, error) {
+	if len(t.PrimaryKey) == 1 && t.PrimaryKey[0] == column {
+		return true, nil
+	}
+	var unique bool
+	err := q.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM pg_constraint c
+			JOIN pg_attribute a ON a.attrelid = c.conrelid AND c.conkey = ARRAY[a.attnum]
+			WHERE c.conrelid = $1::regclass AND c.contype IN ('p', 'u') AND a.attname = $2)`,
+		t.qualified(), column).Scan(&unique)
+	if err != nil {
+		return false, fmt.Errorf("Ошибка чтения ограничений таблицы %s: %v", t, err)
+	}
+	return unique, nil
+}
+
+// Список обычных таблиц схемы
+func listSchemaTables(q queryer, schema string) ([]string, error) {
+	rows, err := q.Query(`
+		SELECT table_name FROM information_schema.tables
+		WHERE table_schema = $1 AND table_type = 'BASE TABLE'
+		ORDER BY table_name`, schema)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка чтения списка таблиц схемы %s: %v", schema, err)
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, schema+"."+name)
+	}
+	return names, rows.Err()
+}
+
+// Определение списка таблиц для передачи на источнике и их описаний.
+// Таблицы упорядочиваются так, чтобы родительские шли раньше ссылающихся на них
+func resolveTables(q queryer, opts transferOptions) ([]*tableInfo, error) {
+	names := opts.Tables
+	if opts.Schema != "" {
+		schemaTables, err := listSchemaTables(q, opts.Schema)
+		if err != nil {
+			return nil, err
+		}
+		names = append(append([]string{}, names...), schemaTables...)
+	}
+	if len(names) == 0 {
+		names = []string{defaultTransferTable}
+	}
+
+	var tables []*tableInfo
+	seen := make(map[string]bool)
+	for _, name := range names {
+		schema, table := splitTableName(name)
+		if seen[schema+"."+table] {
+			continue
+		}
+		seen[schema+"."+table] = true
+		t, err := introspectTable(q, schema, table)
+		if err != nil {
+			return nil, err
+		}
+		if t == nil {
+			return nil, fmt.Errorf("Таблица %s.%s не найдена на сервере-источнике", schema, table)
+		}
+		tables = append(tables, t)
+	}
+	return orderByForeignKeys(q, tables)
+}
+
+// Упорядочивание таблиц по внешним ключам: родительские таблицы раньше дочерних
+func orderByForeignKeys(q queryer, tables []*tableInfo) ([]*tableInfo, error) {
+	if len(tables) < 2 {
+		return tables, nil
+	}
+	rows, err := q.Query(`
+		SELECT cn.nspname || '.' || c.relname, pn.nspname || '.' || p.relname
+		FROM pg_constraint k
+		JOIN pg_class c ON c.oid = k.conrelid
+		JOIN pg_namespace cn ON cn.oid = c.relnamespace
+		JOIN pg_class p ON p.oid = k.confrelid
+		JOIN pg_namespace pn ON pn.oid = p.relnamespace
+		WHERE k.contype = 'f' AND k.conrelid <> k.confrelid`)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка чтения внешних ключей: %v", err)
+	}
+	defer rows.Close()
+
+	byName := make(map[string]*tableInfo)
+	for _, t := range tables {
+		byName[t.String()] = t
+	}
+	parents := make(map[string][]string)
+	for rows.Next() {
+		var child, parent string
+		if err := rows.Scan(&child, &parent); err != nil {
+			return nil, err
+		}
+		if byName[child] != nil && byName[parent] != nil {
+			parents[child] = append(parents[child], parent)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Топологическая сортировка с сохранением исходного порядка
+	var ordered []*tableInfo
+	state := make(map[string]int) // 1 - в обработке, 2 - добавлена
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case 1:
+			return fmt.Errorf("Циклические внешние ключи между таблицами, включая %s", name)
+		case 2:
+			return nil
+		}
+		state[name] = 1
+		for _, parent := range parents[name] {
+			if err := visit(parent); err != nil {
+				return err
+			}
+		}
+		state[name] = 2
+		byName[name].References = parents[name]
+		ordered = append(ordered, byName[name])
+		return nil
+	}
+	for _, t := range tables {
+		if err := visit(t.String()); err != nil {
+			return nil, err
+		}
+	}
+	return ordered, nil
+}
+
+// Определение столбца для CREATE TABLE на приёмнике
+func (c columnInfo) definition() string {
+	def := pq.QuoteIdentifier(c.Name) + " "
+	switch {
+	case c.serial():
+		// SERIAL создаёт на приёмнике собственную последовательность
+		serialTypes := map[string]string{"integer": "serial", "bigint": "bigserial", "smallint": "smallserial"}
+		if serial, ok := serialTypes[c.Type]; ok {
+			def += serial
+		} else {
+			def += c.Type
+		}
+	case c.Generated != "":
+		def += c.Type + " GENERATED ALWAYS AS (" + c.Generated + ") STORED"
+	case c.Identity != "":
+		def += c.Type + " GENERATED " + c.Identity + " AS IDENTITY"
+	default:
+		def += c.Type
+		if c.Default != "" {
+			def += " DEFAULT " + c.Default
+		}
+	}
+	if c.NotNull && !c.serial() {
+		def += " NOT NULL"
+	}
+	return def
+}
+
+// Создание таблицы на приёмнике по описанию таблицы источника
+func createTableLike(q queryer, t *tableInfo) error {
+	var defs []string
+	for _, c := range t.Columns {
+		defs = append(defs, c.definition())
+	}
+	if len(t.PrimaryKey) > 0 {
+		var pk []string
+		for _, col := range t.PrimaryKey {
+			pk = append(pk, pq.QuoteIdentifier(col))
+		}
+		defs = append(defs, "PRIMARY KEY ("+strings.Join(pk, ", ")+")")
+	}
+	if _, err := q.Exec("CREATE SCHEMA IF NOT EXISTS " + pq.QuoteIdentifier(t.Schema)); err != nil {
+		return fmt.Errorf("Ошибка создания схемы %s: %v", t.Schema, err)
+	}
+	query := fmt.Sprintf("CREATE TABLE %s (\n\t%s\n)", t.qualified(), strings.Join(defs, ",\n\t"))
+	fmt.Printf("Выполняю команду CREATE TABLE %s на сервере Б\n", t)
+	if _, err := q.Exec(query); err != nil {
+		return fmt.Errorf("Ошибка создания таблицы %s: %v", t, err)
+	}
+	return nil
+}
+
+// Проверка, что таблица приёмника может принять строки таблицы источника:
+// все передаваемые столбцы есть и имеют тот же тип
+func validateTableLike(src, dst *tableInfo) error {
+	dstCols := make(map[string]columnInfo)
+	for _, c := range dst.Columns {
+		dstCols[c.Name] = c
+	}
+	var problems []string
+	for _, c := range src.dataColumns() {
+		d, ok := dstCols[c.Name]
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("нет столбца %s", c.Name))
+		case d.Type != c.Type:
+			problems = append(problems, fmt.Sprintf("столбец %s имеет тип %s вместо %s", c.Name, d.Type, c.Type))
+		case d.Generated != "":
+			problems = append(problems, fmt.Sprintf("столбец %s вычисляемый", c.Name))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("Таблица %s на приёмнике не совпадает с источником: %s", dst, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Создание отсутствующих таблиц на приёмнике и проверка существующих
+func prepareDestinationTables(q queryer, tables []*tableInfo) error {
+	for _, t := range tables {
+		dst, err := introspectTable(q, t.Schema, t.Name)
+		if err != nil {
+			return err
+		}
+		if dst == nil {
+			if err := createTableLike(q, t); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := validateTableLike(t, dst); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Список столбцов через запятую с экранированием и необязательным суффиксом к каждому
+func columnList(cols []columnInfo, suffix func(columnInfo) string) string {
+	parts := make([]string, len(cols))
+	for i, c := range cols {
+		parts[i] = pq.QuoteIdentifier(c.Name)
+		if suffix != nil {
+			parts[i] += suffix(c)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
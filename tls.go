@@ -0,0 +1,288 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Файлы локального удостоверяющего центра в каталоге tls_dir
+const (
+	caCertFile = "ca.crt"
+	caKeyFile  = "ca.key"
+)
+
+// Файлы сервера в каталоге данных кластера (ssl_cert_file, ssl_key_file и ssl_ca_file)
+const (
+	serverCertFile = "server.crt"
+	serverKeyFile  = "server.key"
+	serverCAFile   = "root.crt"
+)
+
+// Комментарий к параметрам SSL, записанным в postgresql.conf
+const tlsComment = "TLS настроен DBA_Ali"
+
+// Сроки действия сертификатов
+const (
+	caValidity          = 10 * 365 * 24 * time.Hour // Удостоверяющий центр
+	defaultCertValidity = 365                       // Сертификаты сервера и клиентов по умолчанию, дней
+)
+
+// Локальный удостоверяющий центр: сертификат и ключ, которыми подписываются сертификаты
+type certAuthority struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// Каталог удостоверяющего центра кластера (по умолчанию tls в каталоге данных по умолчанию)
+func (c Cluster) tlsDir() string {
+	if c.TLSDir != "" {
+		return c.TLSDir
+	}
+	return filepath.Join(defaultDataRoot(), "tls")
+}
+
+// Случайный серийный номер сертификата
+func newSerialNumber() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+}
+
+// Запись сертификата (права 0644) и ключа (права 0600) в формате PEM
+func writeCertificate(certPath, keyPath string, der []byte, key *ecdsa.PrivateKey) error {
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("Ошибка кодирования ключа %s: %v", keyPath, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(certPath), 0o755); err != nil {
+		return fmt.Errorf("Ошибка создания каталога %s: %v", filepath.Dir(certPath), err)
+	}
+	if err := os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644); err != nil {
+		return fmt.Errorf("Ошибка записи сертификата %s: %v", certPath, err)
+	}
+	// Права существующего файла WriteFile не меняет, а PostgreSQL и libpq требуют 0600
+	os.Remove(keyPath)
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		return fmt.Errorf("Ошибка записи ключа %s: %v", keyPath, err)
+	}
+	return nil
+}
+
+// Чтение сертификата и ключа в формате PEM
+func readCertificate(certPath, keyPath string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	certPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Ошибка чтения сертификата %s: %v", certPath, err)
+	}
+	block, _ := pem.Decode(certPEM)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, nil, fmt.Errorf("Файл %s не содержит сертификата в формате PEM", certPath)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Ошибка разбора сертификата %s: %v", certPath, err)
+	}
+	keyPEM, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Ошибка чтения ключа %s: %v", keyPath, err)
+	}
+	if block, _ = pem.Decode(keyPEM); block == nil {
+		return nil, nil, fmt.Errorf("Файл %s не содержит ключа в формате PEM", keyPath)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Ошибка разбора ключа %s: %v", keyPath, err)
+	}
+	key, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, nil, fmt.Errorf("Ключ %s не является ключом ECDSA", keyPath)
+	}
+	return cert, key, nil
+}
+
+// Загрузка удостоверяющего центра из каталога dir. Если его нет, он создаётся
+func loadOrCreateCA(dir string) (*certAuthority, error) {
+	certPath, keyPath := filepath.Join(dir, caCertFile), filepath.Join(dir, caKeyFile)
+	_, certErr := os.Stat(certPath)
+	_, keyErr := os.Stat(keyPath)
+	if certErr == nil && keyErr == nil {
+		cert, key, err := readCertificate(certPath, keyPath)
+		if err != nil {
+			return nil, err
+		}
+		if time.Now().After(cert.NotAfter) {
+			return nil, fmt.Errorf("Срок действия удостоверяющего центра %s истёк %s", certPath, cert.NotAfter.Format(time.DateOnly))
+		}
+		return &certAuthority{cert: cert, key: key}, nil
+	}
+	if !errors.Is(certErr, os.ErrNotExist) || !errors.Is(keyErr, os.ErrNotExist) {
+		return nil, fmt.Errorf("В каталоге %s есть только один из файлов %s и %s", dir, caCertFile, caKeyFile)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка создания ключа удостоверяющего центра: %v", err)
+	}
+	serial, err := newSerialNumber()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "DBA_Ali local CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка создания сертификата удостоверяющего центра: %v", err)
+	}
+	if err := writeCertificate(certPath, keyPath, der, key); err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("Создан удостоверяющий центр: %s\n", certPath)
+	return &certAuthority{cert: cert, key: key}, nil
+}
+
+// Выпуск сертификата, подписанного удостоверяющим центром. template задаёт имя и назначение,
+// days - срок действия в днях (0 - по умолчанию)
+func (ca *certAuthority) issue(template *x509.Certificate, days int, certPath, keyPath string) error {
+	if days <= 0 {
+		days = defaultCertValidity
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("Ошибка создания ключа: %v", err)
+	}
+	if template.SerialNumber, err = newSerialNumber(); err != nil {
+		return err
+	}
+	now := time.Now()
+	template.NotBefore = now.Add(-time.Hour)
+	template.NotAfter = now.AddDate(0, 0, days)
+	if template.NotAfter.After(ca.cert.NotAfter) {
+		template.NotAfter = ca.cert.NotAfter
+	}
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		return fmt.Errorf("Ошибка создания сертификата %s: %v", template.Subject.CommonName, err)
+	}
+	return writeCertificate(certPath, keyPath, der, key)
+}
+
+// Имена и адреса, по которым проверяется сертификат сервера в режиме verify-full
+func serverCertNames(cluster Cluster) ([]string, []net.IP) {
+	names := []string{"localhost"}
+	ips := []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback}
+	if hostname, err := os.Hostname(); err == nil && hostname != "" && hostname != "localhost" {
+		names = append(names, hostname)
+	}
+	if host := cluster.Host; host != "" && host != "localhost" && !isSocketDir(host) {
+		if ip := net.ParseIP(host); ip != nil {
+			if !ip.IsLoopback() {
+				ips = append(ips, ip)
+			}
+		} else if host != names[len(names)-1] {
+			names = append(names, host)
+		}
+	}
+	return names, ips
+}
+
+// Включение TLS на кластере: сертификат сервера, подписанный локальным удостоверяющим центром,
+// записывается в каталог данных вместе с сертификатом центра, в postgresql.conf включается ssl.
+// Работающий сервер перечитывает конфигурацию. days - срок действия сертификата сервера
+func enableTLS(cluster Cluster, days int) error {
+	if err := cluster.managed(); err != nil {
+		return err
+	}
+	if !isClusterDir(cluster.DataDir) {
+		return fmt.Errorf("Невозможно включить TLS: по пути %s нет кластера", cluster.DataDir)
+	}
+	ca, err := loadOrCreateCA(cluster.tlsDir())
+	if err != nil {
+		return err
+	}
+	names, ips := serverCertNames(cluster)
+	template := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: names[len(names)-1]},
+		DNSNames:    names,
+		IPAddresses: ips,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	err = ca.issue(template, days, filepath.Join(cluster.DataDir, serverCertFile), filepath.Join(cluster.DataDir, serverKeyFile))
+	if err != nil {
+		return err
+	}
+	// Сертификат центра в каталоге данных нужен серверу для проверки сертификатов клиентов
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw})
+	if err := os.WriteFile(filepath.Join(cluster.DataDir, serverCAFile), caPEM, 0o644); err != nil {
+		return fmt.Errorf("Ошибка записи сертификата центра в каталог данных: %v", err)
+	}
+	for _, param := range [][2]string{
+		{"ssl", "on"}, {"ssl_cert_file", serverCertFile}, {"ssl_key_file", serverKeyFile}, {"ssl_ca_file", serverCAFile},
+	} {
+		if err := writeConfParameter(cluster.confFile(), param[0], param[1], tlsComment); err != nil {
+			return err
+		}
+	}
+	reloaded, err := reloadCluster(cluster)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("TLS для сервера %s включён (имена в сертификате: %v)\n", cluster.Name, names)
+	if !reloaded {
+		fmt.Println("Настройки вступят в силу при запуске сервера")
+	}
+	fmt.Printf("Для проверки сервера: sslmode: verify-full, sslrootcert: %s\n", filepath.Join(cluster.tlsDir(), caCertFile))
+	return nil
+}
+
+// Выпуск сертификата клиента для пользователя user, подписанного удостоверяющим центром
+// из каталога dir. Сертификат и ключ записываются в каталог out (по умолчанию dir)
+func issueClientCert(dir, user, out string, days int) error {
+	if user == "" {
+		return fmt.Errorf("Не указан пользователь для сертификата клиента")
+	}
+	// Имя пользователя становится именем файлов, поэтому оно не должно выводить их из каталога out
+	if filepath.Base(user) != user || user == "." || user == ".." {
+		return fmt.Errorf("Имя пользователя %q нельзя использовать как имя файла сертификата", user)
+	}
+	if out == "" {
+		out = dir
+	}
+	ca, err := loadOrCreateCA(dir)
+	if err != nil {
+		return err
+	}
+	// Для аутентификации cert имя в сертификате должно совпадать с пользователем
+	template := &x509.Certificate{
+		Subject:     pkix.Name{CommonName: user},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	certPath, keyPath := filepath.Join(out, user+".crt"), filepath.Join(out, user+".key")
+	if err := ca.issue(template, days, certPath, keyPath); err != nil {
+		return err
+	}
+	fmt.Printf("Сертификат клиента %s выпущен: sslcert: %s, sslkey: %s, sslrootcert: %s\n",
+		user, certPath, keyPath, filepath.Join(dir, caCertFile))
+	return nil
+}
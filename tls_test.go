@@ -0,0 +1,32 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIssueClientCertRejectsPathUser(t *testing.T) {
+	dir, out := t.TempDir(), t.TempDir()
+	for _, user := range []string{"../evil", "a/b", "/etc/passwd", ".", "..", "dba/"} {
+		if err := issueClientCert(dir, user, out, 1); err == nil {
+			t.Errorf("issueClientCert(%q) succeeded, want error", user)
+		}
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("files created for rejected users: %v", entries)
+	}
+
+	if err := issueClientCert(dir, "dba", out, 1); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"dba.crt", "dba.key"} {
+		if _, err := os.Stat(filepath.Join(out, name)); err != nil {
+			t.Error(err)
+		}
+	}
+}
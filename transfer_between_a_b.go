@@ -1,387 +1,821 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
-	_ "github.com/lib/pq" // Драйвер для PostgreSQL
-	"log"
-	"net"
-	"os"
-	"strconv"
+	"github.com/lib/pq" // Драйвер для PostgreSQL
+	"strings"
 	"sync"
 	"time"
 )
 
-// Функция для изменения переменной max_prepared_transactions. server - данные для подключения к серверам
-//func setPreparedTransaction(server string) error {
-//
-//	// Открываем подключение к серверу
-//	db, err := sql.Open("postgres", server)
-//	if err != nil {
-//		return fmt.Errorf("Ошибка подключения к серверу %s: %v", server, err)
-//	}
-//	defer db.Close()
-//
-//	// Устанавливаем переменную max_prepared_transactions на 8
-//	_, err = db.Exec("ALTER SYSTEM SET max_prepared_transactions = 8")
-//	if err != nil {
-//		return fmt.Errorf("Ошибка изменения max_prepared_transactions на сервере %s: %v", server, err)
-//	}
-//
-//	// Перезагружаем конфигурацию
-//	_, err = db.Exec("SELECT pg_reload_conf()")
-//	if err != nil {
-//		return fmt.Errorf("Ошибка перезагрузки конфигурации на сервере %s: %v", server, err)
-//	}
-//
-//	fmt.Printf("Сервер %s успешно настроен для подготовленных транзакций.\n", server)
-//	return nil
-//}
-//
-//// Функция для перезапуска серверов после изменения переменной max_prepared_transactions
-//func restartPostgresServer() error {
-//	// Стоп сервера А
-//	cmd := exec.Command("pg_ctl", "-D", "C:\\TestDir\\Server_A", "stop")
-//	output, err := cmd.CombinedOutput()
-//	if err != nil {
-//		fmt.Printf("Ошибка при остановке сервер: %v, вывод: %s", err, output)
-//	}
-//
-//	// Стоп сервера Б
-//	cmdB := exec.Command("pg_ctl", "-D", "C:\\TestDir\\Server_B", "stop")
-//	outputB, err := cmdB.CombinedOutput()
-//	if err != nil {
-//		fmt.Printf("Ошибка при остановке сервер: %v, вывод: %s", err, outputB)
-//	}
-//	fmt.Println("Сервера успешно остановлены")
-//
-//	// Старт сервера А
-//	cmdSA := exec.Command("pg_ctl", "-D", "C:\\TestDir\\Server_A", "-o", "-p33555", "start")
-//	if err := cmdSA.Run(); err != nil {
-//		return fmt.Errorf("Ошибка при запуске сервера A: %v", err)
-//	}
-//	fmt.Println("Сервер A успешно запущен")
-//
-//	cmdSB := exec.Command("pg_ctl", "-D", "C:\\TestDir\\Server_B", "-o", "-p33556", "start")
-//	if err := cmdSB.Run(); err != nil {
-//		return fmt.Errorf("Ошибка при запуске сервера B: %v", err)
-//	}
-//	fmt.Println("Сервер B успешно запущен")
-//	isClusterRunning("C:\\TestDir\\Server_B")
-//	fmt.Println("Сервер Б успешно поднялся")
-//
-//	return nil
-//}
-
-// Создание БД на серверах А и Б
-func createDataBase(server string) error {
-	db, err := sql.Open("postgres", server)
+// Итог подготовки тестовой БД на сервере
+type provisionReport struct {
+	Server     string
+	Database   string
+	Created    bool  // БД создана
+	Recreated  bool  // БД удалена и создана заново
+	Migrations int   // Применено миграций
+	Rows       int64 // Добавлено сгенерированных строк
+}
+
+func (r provisionReport) String() string {
+	state := "уже существовала"
+	switch {
+	case r.Recreated:
+		state = "пересоздана"
+	case r.Created:
+		state = "создана"
+	}
+	return fmt.Sprintf("Сервер %s: БД %s %s, применено миграций %d, добавлено строк %d",
+		r.Server, r.Database, state, r.Migrations, r.Rows)
+}
+
+// Создание БД на серверах А и Б, если её нет. recreate - удалить существующую БД и создать заново.
+// server - строка подключения, в сообщения об ошибках она не включается
+func createDataBase(server, database string, recreate bool, report *provisionReport) error {
+	db, err := openDB(server, "postgres")
 	if err != nil {
-		return fmt.Errorf("Ошибка подключения к серверу %s: %v", server, err)
+		return fmt.Errorf("Ошибка подключения к серверу: %v", err)
 	}
 	defer db.Close()
 
-	fmt.Println("Выполняю команду CREATE DATABASE database \n")
-	_, err = db.Exec("CREATE DATABASE database")
-	if err != nil {
-		return fmt.Errorf("Ошибка создания БД на сервере %s: %v", server, err)
+	var exists bool
+	if err := db.QueryRow("SELECT EXISTS (SELECT 1 FROM pg_database WHERE datname = $1)", database).Scan(&exists); err != nil {
+		return fmt.Errorf("Ошибка проверки БД: %v", err)
 	}
-	fmt.Printf("Подключение к серверу %s успешно. БД 'database' создана \n", server)
+	if exists && recreate {
+		// Подключения к удаляемой БД завершаются, иначе DROP DATABASE не выполнится
+		if _, err := db.Exec("SELECT pg_terminate_backend(pid) FROM pg_stat_activity WHERE datname = $1 AND pid <> pg_backend_pid()", database); err != nil {
+			return fmt.Errorf("Ошибка завершения подключений к БД %s: %v", database, err)
+		}
+		fmt.Printf("Выполняю команду DROP DATABASE %s\n", database)
+		if _, err := db.Exec("DROP DATABASE " + pq.QuoteIdentifier(database)); err != nil {
+			return fmt.Errorf("Ошибка удаления БД: %v", err)
+		}
+		exists = false
+		report.Recreated = true
+	}
+	if exists {
+		return nil
+	}
+
+	fmt.Printf("Выполняю команду CREATE DATABASE %s\n", database)
+	if _, err := db.Exec("CREATE DATABASE " + pq.QuoteIdentifier(database)); err != nil {
+		return fmt.Errorf("Ошибка создания БД: %v", err)
+	}
+	report.Created = true
 	return nil
 }
 
-// Создание таблиц на серверах А и Б
-func createTables(server string) error {
+// Создание таблиц на серверах А и Б применением встроенных миграций тестовых данных.
+// Уже применённые миграции пропускаются
+func createTables(server, database string, report *provisionReport) error {
 	// Подключаемся к созданной БД
-	db, err := sql.Open("postgres", server+" dbname=database")
+	db, err := openDB(server, database)
 	if err != nil {
-		return fmt.Errorf("Ошибка подключения к БД на сервере %s: %v", server, err)
+		return fmt.Errorf("Ошибка подключения к БД: %v", err)
 	}
 	defer db.Close()
 
-	// Создаём таблицу Data
-	fmt.Println("Выполняю команду CREATE TABLE IF NOT EXISTS Data (id SERIAL PRIMARY KEY, value VARCHAR(100)) \n")
-	_, err = db.Exec("CREATE TABLE IF NOT EXISTS Data (id SERIAL PRIMARY KEY, value VARCHAR(100))")
+	fsys, err := migrationsFS("")
 	if err != nil {
-		return fmt.Errorf("Ошибка при создании таблицы на сервере %s: %v", server, err)
+		return err
+	}
+	if report.Migrations, err = migrateUp(db, fsys, 0); err != nil {
+		return fmt.Errorf("Ошибка при создании таблиц: %v", err)
 	}
-	fmt.Printf("Таблица 'Data' создана на сервере %s. \n", server)
 	return nil
 }
 
-// Наполнение данными только таблицы сервера А
-func dataFill(server string, serverA string, wg *sync.WaitGroup) {
-	db, err := sql.Open("postgres", server+" dbname=database")
+// Наполнение пустых таблиц сервера-источника сгенерированными данными
+func dataFill(server, database string, spec *generateSpec, report *provisionReport) error {
+	db, err := openDB(server, database)
 	if err != nil {
-		log.Fatalf("Ошибка при подключении к БД на сервере %s: %v", server, err)
+		return fmt.Errorf("Ошибка при подключении к БД: %v", err)
 	}
 	defer db.Close()
 
-	// Проверяем, что наполняем только сервер А
-	if server == serverA {
-		for i := 1; i < 10; i++ {
-			_, err = db.Exec("INSERT INTO Data (value) VALUES ($1)", fmt.Sprintf("Value %d", i))
-			if err != nil {
-				log.Fatalf("Ошибка вставки данных на сервере %s: %v", server, err)
-			}
+	report.Rows, err = generateData(db, report.Server, spec, false, true)
+	return err
+}
+
+// БД и таблица, передаваемые по умолчанию
+const (
+	defaultTransferDatabase = "database"
+	defaultTransferTable    = "public.data"
+)
+
+// Параметры передачи данных. Задаются в разделе transfer инвентаря и флагами команды transfer
+type transferOptions struct {
+	Database             string   `yaml:"database"`               // БД на обоих серверах (по умолчанию database)
+	Tables               []string `yaml:"tables"`                 // Таблицы вида schema.table (по умолчанию public.data)
+	Schema               string   `yaml:"schema"`                 // Передать все таблицы схемы
+	SkipFixture          bool     `yaml:"skip_fixture"`           // Не создавать тестовую БД и таблицу Data перед передачей
+	RecreateFixture      bool     `yaml:"recreate_fixture"`       // Пересоздать тестовую БД перед передачей
+	FixtureSpec          string   `yaml:"fixture_spec"`           // Описание генерации данных источника (по умолчанию строки таблицы Data)
+	FixtureRows          int      `yaml:"fixture_rows"`           // Строк в каждой таблице описания генерации
+	Method               string   `yaml:"method"`                 // Способ записи на приёмнике: copy (по умолчанию) или insert
+	BatchSize            int      `yaml:"batch_size"`             // Строк в пакете (по умолчанию 10000)
+	Mode                 string   `yaml:"mode"`                   // move (по умолчанию), copy или archive
+	Where                string   `yaml:"where"`                  // Условие отбора строк на источнике
+	KeyColumn            string   `yaml:"key_column"`             // Столбец диапазона ключей (по умолчанию первичный ключ)
+	KeyFrom              string   `yaml:"key_from"`               // Нижняя граница ключа включительно
+	KeyTo                string   `yaml:"key_to"`                 // Верхняя граница ключа, не включая
+	TimeColumn           string   `yaml:"time_column"`            // Столбец времени для режима archive
+	OlderThan            string   `yaml:"older_than"`             // Граница архивирования: дата или возраст (90d, 12h)
+	ChunkSize            int      `yaml:"chunk_size"`             // Строк в порции с отдельной двухфазной фиксацией (0 - без порций)
+	ResetSourceSequences bool     `yaml:"reset_source_sequences"` // Сбросить последовательности источника после переноса
+	OnConflict           string   `yaml:"on_conflict"`            // Политика конфликтов: fail (по умолчанию), skip, overwrite или table
+	Verify               bool     `yaml:"verify"`                 // Сверять источник и приёмник перед подготовкой транзакций
+	VerifyRanges         int      `yaml:"verify_ranges"`          // Диапазонов ключа при поиске расхождений (по умолчанию 16)
+	ConfigurePrepared    bool     `yaml:"configure_prepared"`     // Настраивать max_prepared_transactions управляемых кластеров без вопроса
+	SimulateCrash        bool     `yaml:"-" json:"-"`             // Имитировать падение сервера B между PREPARE и COMMIT
+	Resume               string   `yaml:"-" json:"-"`             // Идентификатор прерванного запуска для возобновления
+
+	cutoff      time.Time             // Вычисленная граница архивирования
+	runID       string                // Идентификатор запуска для таблицы конфликтов
+	source      string                // Имя сервера-источника для сообщений
+	destination string                // Имя сервера-приёмника для сообщений
+	confirm     func(msg string) bool // Подтверждение действия пользователем в интерактивном режиме
+}
+
+// БД для передачи данных
+func (o transferOptions) database() string {
+	if o.Database != "" {
+		return o.Database
+	}
+	return defaultTransferDatabase
+}
+
+// Описание генерации данных тестовой БД на источнике
+func (o transferOptions) fixtureSpec() (*generateSpec, error) {
+	if o.FixtureSpec == "" {
+		return fixtureSpec(o.FixtureRows), nil
+	}
+	spec, err := loadGenerateSpec(o.FixtureSpec)
+	if err != nil {
+		return nil, err
+	}
+	return spec.withRows(o.FixtureRows), nil
+}
+
+// Способ записи строк на приёмнике. Политики конфликтов, кроме fail, записывают строки INSERT
+func (o transferOptions) method() string {
+	if o.Method != "" {
+		return o.Method
+	}
+	if o.onConflict() != conflictFail {
+		return transferMethodInsert
+	}
+	return transferMethodCopy
+}
+
+// Размер пакета строк
+func (o transferOptions) batchSize() int {
+	if o.BatchSize > 0 {
+		return o.BatchSize
+	}
+	return defaultBatchSize
+}
+
+// Число диапазонов ключа при поиске расхождений
+func (o transferOptions) verifyRanges() int {
+	if o.VerifyRanges > 0 {
+		return o.VerifyRanges
+	}
+	return defaultVerifyRanges
+}
+
+// Проверка параметров передачи до начала транзакций и вычисление границы архивирования
+func (o *transferOptions) validate(now time.Time) error {
+	switch o.method() {
+	case transferMethodCopy, transferMethodInsert:
+	default:
+		return fmt.Errorf("Неизвестный способ передачи %q (допустимо: %s, %s)", o.Method, transferMethodCopy, transferMethodInsert)
+	}
+	switch o.onConflict() {
+	case conflictFail, conflictSkip, conflictOverwrite, conflictTable:
+	default:
+		return fmt.Errorf("Неизвестная политика конфликтов %q (допустимо: %s, %s, %s, %s)",
+			o.OnConflict, conflictFail, conflictSkip, conflictOverwrite, conflictTable)
+	}
+	if o.ResetSourceSequences && !o.deletesSource() {
+		return fmt.Errorf("Сброс последовательностей источника невозможен в режиме %s", transferModeCopy)
+	}
+	if o.onConflict() != conflictFail && o.Method == transferMethodCopy {
+		return fmt.Errorf("Политика конфликтов %s выполняется командой INSERT и несовместима со способом %s", o.onConflict(), transferMethodCopy)
+	}
+	if o.BatchSize < 0 {
+		return fmt.Errorf("Размер пакета должен быть положительным: %d", o.BatchSize)
+	}
+	if o.ChunkSize < 0 {
+		return fmt.Errorf("Размер порции должен быть положительным: %d", o.ChunkSize)
+	}
+	switch o.mode() {
+	case transferModeMove, transferModeCopy:
+		if o.TimeColumn != "" || o.OlderThan != "" {
+			return fmt.Errorf("Столбец времени и граница архивирования задаются только в режиме %s", transferModeArchive)
+		}
+	case transferModeArchive:
+		if o.TimeColumn == "" || o.OlderThan == "" {
+			return fmt.Errorf("Для режима %s нужны столбец времени и граница архивирования", transferModeArchive)
+		}
+		cutoff, err := parseOlderThan(o.OlderThan, now)
+		if err != nil {
+			return err
 		}
-		fmt.Println("Таблица 'Data' заполнена данными на сервере А. \n")
+		o.cutoff = cutoff
+	default:
+		return fmt.Errorf("Неизвестный режим передачи %q (допустимо: %s, %s, %s)", o.Mode, transferModeMove, transferModeCopy, transferModeArchive)
+	}
+	if o.KeyColumn != "" && o.KeyFrom == "" && o.KeyTo == "" && o.ChunkSize == 0 {
+		return fmt.Errorf("Для столбца ключа %s не задан диапазон", o.KeyColumn)
 	}
+	return nil
 }
 
-func transferDataWith2PC(serverA, serverB string, simulateCrash bool, wg *sync.WaitGroup) {
-	defer wg.Done()
+// Участник передачи данных: кластер из инвентаря и строка подключения к нему
+type participant struct {
+	Cluster Cluster
+	Server  string
+}
 
-	_, portStr, err := net.SplitHostPort(serverB)
-	if err != nil {
-		log.Fatalf("Ошибка при извлечении порта из serverB: %v", err)
+// Максимальное число повторов COMMIT PREPARED после записи решения о фиксации
+const commitPreparedRetries = 5
+
+// Выполнение COMMIT PREPARED с повторами. После записи решения о фиксации откат недопустим,
+// поэтому при исчерпании повторов транзакция остаётся подготовленной до команды recover
+func commitPreparedWithRetry(db *sql.DB, gid, name string) error {
+	retryCount := 0
+	for {
+		_, err := db.Exec("COMMIT PREPARED " + pq.QuoteLiteral(gid))
+		if err == nil {
+			return nil
+		}
+		if retryCount >= commitPreparedRetries {
+			return err
+		}
+		fmt.Printf("Ошибка коммита на сервере %s, повторная попытка %d...\n", name, retryCount+1)
+		retryCount++
+		time.Sleep(2 * time.Second)
 	}
+}
 
-	portB, err := strconv.Atoi(portStr)
-	if err != nil {
-		log.Fatalf("Ошибка при конвертации порта в число: %v", err)
+// Откат подготовленных транзакций с записью решения об откате в журнал координатора.
+// prepared - участники, на которых PREPARE TRANSACTION уже выполнен. Откаченные и не
+// подготовленные участники отмечаются в журнале завершёнными, чтобы запуск можно было
+// возобновить. Возвращает ошибку, если какую-либо транзакцию откатить не удалось
+func abortPrepared(coord *coordinatorLog, runID string, gids []participantGID, prepared map[string]*sql.DB) error {
+	if err := coord.append(runID, coordAbort, gids); err != nil {
+		fmt.Printf("Ошибка записи решения об откате в журнал координатора: %v\n", err)
 	}
+	var failed []error
+	var done []participantGID
+	for _, p := range gids {
+		db, ok := prepared[p.GID]
+		if !ok {
+			done = append(done, p)
+			continue
+		}
+		fmt.Printf("ОШИБКА. Выполняю команду ROLLBACK PREPARED '%s' на сервере %s\n", p.GID, p.Cluster)
+		if _, err := db.Exec("ROLLBACK PREPARED " + pq.QuoteLiteral(p.GID)); err != nil {
+			fmt.Printf("Ошибка отката %s на сервере %s: %v. Выполните команду recover\n", p.GID, p.Cluster, err)
+			failed = append(failed, err)
+			continue
+		}
+		done = append(done, p)
+	}
+	if len(done) > 0 {
+		if err := coord.append(runID, coordDone, done); err != nil {
+			fmt.Printf("Ошибка записи в журнал координатора: %v\n", err)
+		}
+	}
+	return errors.Join(failed...)
+}
 
-	// Подключение к обеим базам данных
-	dbA, err := sql.Open("postgres", serverA+" dbname=database")
+// Один раунд двухфазной фиксации: перенос строк таблиц (или порции chunk одной таблицы)
+// в паре подготавливаемых транзакций. Решения о фиксации и откате записываются в журнал
+// координатора coord до их выполнения на серверах, для порции вместе с контрольной точкой.
+// Возвращает false, если в порции не оказалось строк и фиксировать нечего. При ошибке
+// незавершённые транзакции откатываются, ошибка содержит этап и сервер
+func transferRound(a, b participant, dbA, dbB *sql.DB, coord *coordinatorLog, runID string, opts transferOptions, chunk *tableChunk) ([]*tableInfo, map[string]tableStats, bool, error) {
+	clusterB := b.Cluster
+	nameA, nameB := a.Cluster.Name, b.Cluster.Name
+
+	// Журнал координатора блокируется на весь раунд: пока транзакции подготовлены, но решение
+	// не записано, команда recover не должна их трогать
+	unlock, err := coord.lock(true)
 	if err != nil {
-		log.Fatalf("Ошибка подключения к серверу A: %v", err)
+		return nil, nil, false, newTransferError(phaseCoordinator, "", err)
 	}
-	defer dbA.Close()
+	defer unlock()
 
-	dbB, err := sql.Open("postgres", serverB+" dbname=database")
+	// Начало транзакций на обоих серверах. На источнике используется снимок REPEATABLE READ,
+	// чтобы на нём удалялись ровно те строки, которые переданы на приёмник
+	fmt.Printf("Выполняю команду BEGIN ISOLATION LEVEL REPEATABLE READ на сервере %s\n", nameA)
+	txA, err := dbA.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead})
 	if err != nil {
-		log.Fatalf("Ошибка подключения к серверу B: %v", err)
+		return nil, nil, false, newTransferError(phaseBegin, nameA, err)
 	}
-	defer dbB.Close()
+	// Rollback после PREPARE TRANSACTION или COMMIT ничего не делает
+	defer txA.Rollback()
 
-	// Начало транзакций на обоих серверах
-	txA, err := dbA.Begin()
-	fmt.Println("Выполняю команду BEGIN на сервере А \n")
+	fmt.Printf("Выполняю команду BEGIN на сервере %s\n", nameB)
+	txB, err := dbB.Begin()
 	if err != nil {
-		log.Fatalf("Ошибка начала транзакции на сервере A: %v", err)
+		return nil, nil, false, newTransferError(phaseBegin, nameB, err)
+	}
+	defer txB.Rollback()
+	// Строки передаются в текстовом представлении, которое не должно зависеть от настроек серверов
+	if err := pinTextSettings(txA, nameA); err != nil {
+		return nil, nil, false, newTransferError(phaseBegin, nameA, err)
+	}
+	if err := pinTextSettings(txB, nameB); err != nil {
+		return nil, nil, false, newTransferError(phaseBegin, nameB, err)
 	}
 
-	txB, err := dbB.Begin()
-	fmt.Println("Выполняю команду BEGIN на сервере Б \n")
+	// Определение таблиц на источнике и подготовка таблиц на приёмнике
+	tables, err := resolveTables(txA, opts)
 	if err != nil {
-		log.Fatalf("Ошибка начала транзакции на сервере B: %v", err)
+		return nil, nil, false, newTransferError(phaseTables, nameA, err)
+	}
+	if err := prepareDestinationTables(txB, tables); err != nil {
+		return nil, nil, false, newTransferError(phaseTables, nameB, err)
 	}
 
-	// Подготовка передачи данных
-	fmt.Println("Выполняю команду DELETE FROM Data RETURNING id, value FROM Data \n")
-	rows, err := txA.Query("DELETE FROM Data RETURNING id, value")
+	// Перенос строк
+	stats, err := moveTables(txA, txB, tables, opts, chunk)
 	if err != nil {
-		txA.Rollback()
-		txB.Rollback()
-		log.Fatalf("Ошибка выборки данных на сервере A: %v", err)
-	}
-	defer rows.Close()
-
-	currentRow := 0
-	for rows.Next() {
-		currentRow++
-		var id int
-		var value string
-		fmt.Printf("\rВыполняю сканирование строки %d", currentRow)
-		os.Stdout.Sync()
-		if err := rows.Scan(&id, &value); err != nil {
-			txA.Rollback()
-			txB.Rollback()
-			log.Fatalf("Ошибка сканирования строки на сервере A: %v", err)
-		}
-		_, err = txB.Exec("INSERT INTO Data (id, value) VALUES ($1, $2)", id, value)
-		if err != nil {
-			txA.Rollback()
-			txB.Rollback()
-			log.Fatalf("Ошибка вставки данных на сервере B: %v", err)
+		return tables, stats, false, newTransferError(phaseCopy, "", err)
+	}
+	if err := advanceSequences(txB, tables, nameB); err != nil {
+		return tables, stats, false, newTransferError(phaseSequences, "", err)
+	}
+	var checkpoint *transferCheckpoint
+	if chunk != nil {
+		rows := stats[tables[0].String()].Rows
+		if rows == 0 {
+			return tables, stats, false, nil
 		}
+		checkpoint = &transferCheckpoint{Table: tables[0].String(), Chunk: chunk.Number, LastKey: chunk.Last, Rows: chunk.Done + rows}
 	}
 
-	// Подготовка транзакций
-	prepareTxA := "PREPARE TRANSACTION 'txA'"
-	prepareTxB := "PREPARE TRANSACTION 'txB'"
+	// Подготовка транзакций. Намерение подготовить транзакции записывается в журнал
+	// координатора до PREPARE, чтобы команда recover могла найти их после падения
+	gidA, gidB := newGID(runID, nameA), newGID(runID, nameB)
+	gids := []participantGID{{Cluster: nameA, GID: gidA}, {Cluster: nameB, GID: gidB}}
+	if err := coord.append(runID, coordPrepared, gids); err != nil {
+		return tables, stats, false, newTransferError(phaseCoordinator, "", err)
+	}
+	prepared := make(map[string]*sql.DB)
+	// Откат уже подготовленных транзакций. Если откатить не удалось, решение об откате
+	// записано в журнал и транзакции завершит команда recover
+	abort := func(phase transferPhase, server string, err error) error {
+		te := &transferError{Phase: phase, Server: server, RunID: runID, Err: err}
+		te.InDoubt = abortPrepared(coord, runID, gids, prepared) != nil
+		return te
+	}
 
-	fmt.Println("Выполняю команду PREPARE TRANSACTION 'txA' на сервере А \n")
-	if _, err := txA.Exec(prepareTxA); err != nil {
-		txA.Rollback()
-		txB.Rollback()
-		log.Fatalf("Ошибка подготовки транзакции на сервере A: %v", err)
+	fmt.Printf("Выполняю команду PREPARE TRANSACTION '%s' на сервере %s\n", gidA, nameA)
+	if _, err := txA.Exec("PREPARE TRANSACTION " + pq.QuoteLiteral(gidA)); err != nil {
+		return tables, stats, false, abort(phasePrepare, nameA, err)
 	}
+	prepared[gidA] = dbA
 
-	fmt.Println("Выполняю команду PREPARE TRANSACTION 'txB' на сервере Б \n")
-	if _, err := txB.Exec(prepareTxB); err != nil {
-		txA.Rollback()
-		txB.Rollback()
-		log.Fatalf("Ошибка подготовки транзакции на сервере B: %v", err)
+	fmt.Printf("Выполняю команду PREPARE TRANSACTION '%s' на сервере %s\n", gidB, nameB)
+	if _, err := txB.Exec("PREPARE TRANSACTION " + pq.QuoteLiteral(gidB)); err != nil {
+		return tables, stats, false, abort(phasePrepare, nameB, err)
 	}
+	prepared[gidB] = dbB
 
-	// Симуляция жесткого падения сервера B
-	if simulateCrash {
-		fmt.Println("Симуляция жесткого падения сервера B")
+	// Симуляция жесткого падения сервера-приёмника
+	if opts.SimulateCrash {
+		fmt.Printf("Симуляция жесткого падения сервера %s\n", nameB)
 		time.Sleep(5 * time.Second) // Пауза в 5 секунд для имитации падения
-		if err := StopCluster("C:\\TestDir\\Server_B", "localhost", portB); err != nil {
-			log.Fatalf("Ошибка при симуляции падения сервера B: %v", err)
+		if err := StopCluster(clusterB, stopImmediate); err != nil {
+			return tables, stats, false, abort(phaseCrash, nameB, err)
+		}
+		fmt.Printf("Сервер %s успешно остановлен для симуляции падения.\n", nameB)
+
+		// Попытка поднять сервер после падения. StartCluster дожидается готовности сервера
+		if err := StartCluster(clusterB); err != nil {
+			return tables, stats, false, abort(phaseCrash, nameB, fmt.Errorf("сервер не запустился после падения: %v", err))
+		}
+		fmt.Printf("Сервер %s успешно перезапущен после симуляции падения.\n", nameB)
+	}
+
+	// Решение о фиксации записывается до COMMIT PREPARED. Если записать его не удалось,
+	// транзакции откатываются
+	if err := coord.write(coordinatorRecord{RunID: runID, State: coordCommit, Participants: gids, Checkpoint: checkpoint}); err != nil {
+		return tables, stats, false, abort(phaseCoordinator, "", err)
+	}
+
+	// Коммит подготовленных транзакций. После записи решения откат недопустим, поэтому
+	// ошибка оставляет транзакции до команды recover
+	fmt.Printf("Выполняю команду COMMIT PREPARED '%s' на сервере %s\n", gidA, nameA)
+	if err := commitPreparedWithRetry(dbA, gidA, nameA); err != nil {
+		return tables, stats, false, &transferError{Phase: phaseCommit, Server: nameA, RunID: runID, InDoubt: true, Err: err}
+	}
+
+	fmt.Printf("Выполняю команду COMMIT PREPARED '%s' на сервере %s\n", gidB, nameB)
+	if err := commitPreparedWithRetry(dbB, gidB, nameB); err != nil {
+		return tables, stats, false, &transferError{Phase: phaseCommit, Server: nameB, RunID: runID, InDoubt: true, Err: err}
+	}
+
+	if err := coord.append(runID, coordDone, gids); err != nil {
+		fmt.Printf("Ошибка записи в журнал координатора: %v\n", err)
+	}
+	// Сброс последовательностей источника только после фиксации: setval не откатывается,
+	// и при откате передачи последовательность осталась бы заниженной. Данные уже
+	// зафиксированы, поэтому ошибка сброса не прерывает передачу
+	if opts.ResetSourceSequences && opts.deletesSource() {
+		if err := resetSequences(dbA, tables, nameA); err != nil {
+			fmt.Printf("Данные переданы, но последовательности сервера %s не сброшены: %v\n", nameA, err)
+		}
+	}
+	return tables, stats, true, nil
+}
+
+// Вывод статистики передачи по таблицам и итога
+func printTransferSummary(tables []*tableInfo, stats map[string]tableStats, opts transferOptions) {
+	var total tableStats
+	for _, t := range tables {
+		fmt.Printf("Таблица %s: %s\n", t, stats[t.String()])
+		total.add(stats[t.String()])
+	}
+	fmt.Printf("Всего (%s, пакет %d строк, конфликты: %s): %s\n", opts.method(), opts.batchSize(), opts.onConflict(), total)
+	if opts.deletesSource() {
+		fmt.Printf("Передача данных завершена успешно, данные на сервере %s удалены.\n", opts.source)
+	} else {
+		fmt.Printf("Копирование данных завершено успешно, данные на сервере %s сохранены.\n", opts.source)
+	}
+}
+
+// Передача данных с сервера A на сервер B в рамках двухфазной фиксации. runID - идентификатор
+// запуска, из которого формируются GID подготовленных транзакций. С opts.ChunkSize каждая
+// порция передаётся отдельным раундом; checkpoint - место, с которого возобновляется запуск.
+// Ошибка содержит этап и сервер, транзакции к её возврату откачены или записаны в журнал
+func transferDataWith2PC(a, b participant, coord *coordinatorLog, runID string, opts transferOptions, checkpoint *transferCheckpoint) error {
+	// Подключение к обеим базам данных
+	dbA, err := openDB(a.Server, opts.database())
+	if err != nil {
+		return withRunID(newTransferError(phaseConnect, a.Cluster.Name, err), runID)
+	}
+	defer dbA.Close()
+
+	dbB, err := openDB(b.Server, opts.database())
+	if err != nil {
+		return withRunID(newTransferError(phaseConnect, b.Cluster.Name, err), runID)
+	}
+	defer dbB.Close()
+
+	if opts.ChunkSize == 0 {
+		tables, stats, _, err := transferRound(a, b, dbA, dbB, coord, runID, opts, nil)
+		if err != nil {
+			return withRunID(err, runID)
 		}
-		fmt.Println("Сервер B успешно остановлен для симуляции падения.")
+		printTransferSummary(tables, stats, opts)
+		return nil
+	}
 
-		// Попытка поднять сервер B после падения
-		if err := StartCluster("C:\\TestDir\\Server_B", "localhost", portB); err != nil {
-			log.Fatalf("Ошибка при попытке поднять сервер B после падения: %v", err)
+	// Порционная передача: таблицы по очереди, каждая порция в порядке ключа
+	tables, err := resolveTables(dbA, opts)
+	if err != nil {
+		return withRunID(newTransferError(phaseTables, a.Cluster.Name, err), runID)
+	}
+	if err := validateChunkedTables(dbA, tables, opts); err != nil {
+		return withRunID(newTransferError(phaseTables, a.Cluster.Name, err), runID)
+	}
+	first := 0
+	if checkpoint != nil {
+		first = -1
+		for i, t := range tables {
+			if t.String() == checkpoint.Table {
+				first = i
+			}
+		}
+		if first < 0 {
+			return withRunID(newTransferError(phaseTables, a.Cluster.Name,
+				fmt.Errorf("Таблица %s из контрольной точки не найдена среди передаваемых таблиц", checkpoint.Table)), runID)
 		}
-		fmt.Println("Сервер B успешно перезапущен после симуляции падения.")
+		fmt.Printf("Возобновление с таблицы %s после ключа %s (порций %d, строк %d)\n",
+			checkpoint.Table, checkpoint.LastKey, checkpoint.Chunk, checkpoint.Rows)
+	}
 
-		// Проверка, что сервер B запущен
+	totals := make(map[string]tableStats)
+	for _, t := range tables[first:] {
+		key, _ := opts.keyColumn(t)
+		chunk := tableChunk{Key: key, Limit: opts.ChunkSize}
+		if checkpoint != nil && checkpoint.Table == t.String() {
+			after := checkpoint.LastKey
+			chunk.After, chunk.Number, chunk.Done = &after, checkpoint.Chunk, checkpoint.Rows
+		}
+		roundOpts := opts
+		roundOpts.Tables, roundOpts.Schema = []string{t.String()}, ""
 		for {
-			running, err := isClusterRunning("C:\\TestDir\\Server_B")
+			chunk.Number++
+			fmt.Printf("Порция %d таблицы %s\n", chunk.Number, t)
+			_, stats, committed, err := transferRound(a, b, dbA, dbB, coord, runID, roundOpts, &chunk)
 			if err != nil {
-				log.Fatalf("Ошибка при проверке статуса сервера B: %v", err)
+				// Зафиксированные порции сохранены в журнале, запуск можно возобновить
+				for name, total := range totals {
+					fmt.Printf("Таблица %s: зафиксировано до ошибки %s\n", name, total)
+				}
+				err = withRunID(err, runID)
+				if inDoubt(err) {
+					fmt.Printf("После команды recover передачу можно продолжить флагом -resume %s\n", runID)
+				} else {
+					fmt.Printf("Передачу можно продолжить флагом -resume %s\n", runID)
+				}
+				return err
+			}
+			if !committed {
+				break
 			}
-			if running {
+			s := stats[t.String()]
+			total := totals[t.String()]
+			total.add(s)
+			totals[t.String()] = total
+			chunk.Done += s.Rows
+			after := chunk.Last
+			chunk.After = &after
+			roundOpts.SimulateCrash = false
+			if s.Rows < int64(opts.ChunkSize) {
 				break
 			}
-			fmt.Println("Ожидание запуска сервера B...")
-			time.Sleep(1 * time.Second)
 		}
 	}
+	printTransferSummary(tables, totals, opts)
+	return nil
+}
+
+// Подготовка тестовой БД: создание БД и таблиц, которых нет, и наполнение пустых таблиц
+// по описанию генерации fill (nil - не наполнять). Ошибка сохраняется в err, процесс не завершается
+func createDataBaseNTables(name, server, database string, fill *generateSpec, recreate bool, err *error, wg *sync.WaitGroup) {
+	defer wg.Done()
+	report := provisionReport{Server: name, Database: database}
+	*err = createDataBase(server, database, recreate, &report)
+	if *err == nil {
+		*err = createTables(server, database, &report)
+	}
+	if *err == nil && fill != nil {
+		*err = dataFill(server, database, fill, &report)
+	}
+	if *err != nil {
+		*err = fmt.Errorf("Подготовка тестовой БД на сервере %s не выполнена: %v", name, *err)
+		return
+	}
+	fmt.Println(report)
+}
 
-	// Коммит подготовленных транзакций
-	fmt.Println("Выполняю команду COMMIT PREPARED 'txA' на сервере А \n")
-	if _, err := dbA.Exec("COMMIT PREPARED 'txA'"); err != nil {
-		fmt.Println("ОШИБКА. Выполняю команду ROLLBACK PREPARED 'txA' на сервере А \n")
-		_, _ = dbA.Exec("ROLLBACK PREPARED 'txA'")
-		fmt.Println("ОШИБКА. Выполняю команду ROLLBACK PREPARED 'txB' на сервере Б \n")
-		_, _ = dbB.Exec("ROLLBACK PREPARED 'txB'")
-		log.Fatalf("Ошибка коммита подготовленной транзакции на сервере A: %v", err)
+// Параметры подключения к серверу. Пустые поля заменяются значениями по умолчанию
+type connParams struct {
+	User        string
+	Password    string
+	Host        string
+	Port        string
+	SSLMode     string // Режим SSL libpq (y/n - прежние значения require/disable)
+	SSLRootCert string
+	SSLCert     string
+	SSLKey      string
+}
+
+// Экранирование значения для строки подключения libpq: пустые значения и значения
+// с пробелами, кавычками или обратной косой чертой заключаются в одинарные кавычки
+func conninfoValue(v string) string {
+	if v != "" && !strings.ContainsAny(v, " '\\") {
+		return v
 	}
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(v) + "'"
+}
 
-	fmt.Println("Выполняю команду COMMIT PREPARED 'txB' на сервере Б \n")
-	retryCount := 0
-	maxRetries := 5
-	for {
-		_, err := dbB.Exec("COMMIT PREPARED 'txB'")
-		if err == nil {
-			break
-		}
-		if retryCount >= maxRetries {
-			fmt.Println("ОШИБКА. Выполняю команду ROLLBACK PREPARED 'txA' на сервере А \n")
-			_, _ = dbA.Exec("ROLLBACK PREPARED 'txA'")
-			fmt.Println("ОШИБКА. Выполняю команду ROLLBACK PREPARED 'txB' на сервере Б \n")
-			_, _ = dbB.Exec("ROLLBACK PREPARED 'txB'")
-			log.Fatalf("Ошибка коммита подготовленной транзакции на сервере B: %v", err)
+// Формирование строки подключения из параметров. host может быть каталогом Unix-сокета
+func (p connParams) conninfo() string {
+	p = p.withSSLDefaults()
+	if p.Host == "" {
+		p.Host = "localhost"
+	}
+	if p.User == "" {
+		p.User = "postgres"
+	}
+	if p.Port == "" {
+		p.Port = "5432"
+	}
+	info := fmt.Sprintf("user=%s host=%s port=%s sslmode=%s",
+		conninfoValue(p.User), conninfoValue(p.Host), conninfoValue(p.Port), p.SSLMode)
+	for _, param := range []struct{ key, value string }{
+		{"sslrootcert", p.SSLRootCert}, {"sslcert", p.SSLCert}, {"sslkey", p.SSLKey}, {"password", p.Password},
+	} {
+		if param.value != "" {
+			info += " " + param.key + "=" + conninfoValue(param.value)
 		}
-		fmt.Printf("Ошибка коммита на сервере B, повторная попытка %d...\n", retryCount+1)
-		retryCount++
-		time.Sleep(2 * time.Second)
 	}
+	return info
+}
 
-	fmt.Println("Передача данных завершена успешно, данные на сервере A удалены.")
+// Запрос параметров подключения к серверу у пользователя.
+// name - имя сервера в подсказках, defaults - значения из инвентаря, подставляемые при пустом вводе
+func promptConnParams(name string, defaults connParams) connParams {
+	p := defaults
+	var input string
+	prompt := func(format string, field *string) {
+		fmt.Printf(format, name, *field)
+		input = ""
+		fmt.Scanln(&input)
+		if input != "" {
+			*field = input
+		}
+	}
+	prompt("Введите имя пользователя для сервера %s (оставьте пустым, если %s): ", &p.User)
+	// Пароль не отображается при вводе. Пустой ввод - пароль из инвентаря, PGPASSWORD или файла паролей
+	fmt.Printf("Введите пароль для сервера %s (оставьте пустым, если не требуется или задан в PGPASSWORD или .pgpass): ", name)
+	if input = readPassword(); input != "" {
+		p.Password = input
+		registerSecret(input)
+	}
+	prompt("Введите host для сервера %s (оставьте пустым, если %s): ", &p.Host)
+	prompt("Введите port для сервера %s (оставьте пустым если %s): ", &p.Port)
+	p.SSLMode = normalizeSSLMode(p.SSLMode)
+	prompt("Режим SSL для сервера %s: disable, allow, prefer, require, verify-ca или verify-full (оставьте пустым, если %s): ", &p.SSLMode)
+	p.SSLMode = normalizeSSLMode(p.SSLMode)
+	if p.SSLMode != sslDisable {
+		optional := func(format string, field *string) {
+			fmt.Printf(format, name, *field)
+			input = ""
+			fmt.Scanln(&input)
+			switch input {
+			case "":
+			case "-":
+				*field = ""
+			default:
+				*field = input
+			}
+		}
+		optional("Корневой сертификат для сервера %s (оставьте пустым, если %q; - если не нужен): ", &p.SSLRootCert)
+		optional("Сертификат клиента для сервера %s (оставьте пустым, если %q; - если не нужен): ", &p.SSLCert)
+		if p.SSLCert != "" {
+			optional("Ключ сертификата клиента для сервера %s (оставьте пустым, если %q): ", &p.SSLKey)
+		} else {
+			p.SSLKey = ""
+		}
+	}
+	return p
 }
 
-// Создание БД, таблиц и их наполнение вместе. server - данные серверов для подключения
-func createDataBaseNTables(server string, serverA string, wg *sync.WaitGroup) {
-	defer wg.Done()
-	if err := createDataBase(server); err != nil {
-		log.Println(err)
+// Основная функция интерактивного режима: запрашивает параметры и выполняет передачу
+// между серверами, указанными в инвентаре
+func TransferData(inv *Inventory) {
+	clusterA, err := inv.Cluster(inv.Transfer.Source)
+	if err != nil {
+		fmt.Println(err)
 		return
 	}
-	if err := createTables(server); err != nil {
-		log.Println(err)
+	clusterB, err := inv.Cluster(inv.Transfer.Destination)
+	if err != nil {
+		fmt.Println(err)
+		return
 	}
-	dataFill(server, serverA, wg)
 
-}
+	// Запрос данных для подключения к кластерам серверов A и B. Параметры SSL проверяются
+	// до подключения
+	paramsA := promptConnParams(clusterA.Name, clusterA.connParams())
+	if err := paramsA.validate(); err != nil {
+		fmt.Printf("Сервер %s: %v\n", clusterA.Name, err)
+		return
+	}
+	paramsB := promptConnParams(clusterB.Name, clusterB.connParams())
+	if err := paramsB.validate(); err != nil {
+		fmt.Printf("Сервер %s: %v\n", clusterB.Name, err)
+		return
+	}
 
-// Основная функция
-func TransferData() {
-	var wg sync.WaitGroup
+	opts := inv.Transfer.transferOptions
+	var tablesResponse string
+	fmt.Printf("Введите таблицы для передачи через запятую (оставьте пустым, если %s): ", strings.Join(opts.tableNames(), ","))
+	fmt.Scanln(&tablesResponse)
+	if tablesResponse != "" {
+		opts.Tables = strings.Split(tablesResponse, ",")
+		opts.Schema = ""
+	}
 
-	// Запрос данных для подключения к кластеру сервера A
-	var userA, passwordA, hostA, portA, sslA string
-	fmt.Print("Введите имя пользователя для сервера A (оставьте пустым, если postgres): ")
-	fmt.Scanln(&userA)
-	fmt.Print("Введите пароль для сервера A (оставьте пустым, если не требуется): ")
-	fmt.Scanln(&passwordA)
-	fmt.Print("Введите host для сервера A (оставьте пустым, если localhost): ")
-	fmt.Scanln(&hostA)
-	fmt.Print("Введите port для сервера A (оставьте пустым если 5432): ")
-	fmt.Scanln(&portA)
-	fmt.Print("Использовать SSL для сервера A? (y/n) (оставьте пустым, если disabled): ")
-	fmt.Scanln(&sslA)
-
-	// Запрос данных для подключения к кластеру сервера B
-	var userB, passwordB, hostB, portB, sslB string
-	fmt.Print("Введите имя пользователя для сервера B (оставьте пустым, если postgres): ")
-	fmt.Scanln(&userB)
-	fmt.Print("Введите пароль для сервера B (оставьте пустым, если не требуется): ")
-	fmt.Scanln(&passwordB)
-	fmt.Print("Введите host для сервера B (оставьте пустым, если localhost): ")
-	fmt.Scanln(&hostB)
-	fmt.Print("Введите port для сервера B (оставьте пустым если 5432): ")
-	fmt.Scanln(&portB)
-	fmt.Print("Использовать SSL для сервера B? (оставьте пустым, если disabled): ")
-	fmt.Scanln(&sslB)
-
-	// Формирование строк подключения
-	sslModeA := "disable"
-	if sslA == "y" {
-		sslModeA = "enable"
-	}
-	if hostA == "" {
-		hostA = "localhost"
-	}
-	if userA == "" {
-		userA = "postgres"
-	}
-	if portA == "" {
-		portA = "5432"
-	}
-	serverA := fmt.Sprintf("user=%s password=%s host=%s port=%s sslmode=%s", userA, passwordA, hostA, portA, sslModeA)
-
-	sslModeB := "disable"
-	if sslB == "y" {
-		sslModeB = "enable"
-	}
-	if hostB == "" {
-		hostB = "localhost"
-	}
-	if userB == "" {
-		userB = "postgres"
-	}
-	if portB == "" {
-		portB = "5432"
-	}
-	serverB := fmt.Sprintf("user=%s password=%s host=%s port=%s sslmode=%s", userB, passwordB, hostB, portB, sslModeB)
-	//if err := setPreparedTransaction(serverA); err != nil {
-	//	log.Fatalf("Ошибка настройки сервера A: %v", err)
-	//}
-	//if err := setPreparedTransaction(serverB); err != nil {
-	//	log.Fatalf("Ошибка настройки сервера Б: %v", err)
-	//}
-	//
-	//if err := restartPostgresServer(); err != nil {
-	//	log.Fatalf("Ошибка перезапуска серверов: %v", err)
-	//}
-
-	simulateCrash := false
 	var simulateCrashResponse string
 	fmt.Print("Хотите ли вы иммитировать падние сервера B? (y/n): ")
 	fmt.Scanln(&simulateCrashResponse)
 	if simulateCrashResponse == "y" {
-		simulateCrash = true
+		opts.SimulateCrash = true
+	}
+	opts.confirm = func(msg string) bool {
+		var response string
+		fmt.Printf("%s (y/n): ", msg)
+		fmt.Scanln(&response)
+		return response == "y"
+	}
+
+	a := participant{Cluster: clusterA, Server: paramsA.conninfo()}
+	b := participant{Cluster: clusterB, Server: paramsB.conninfo()}
+	if err := runTransfer(inv, a, b, opts); err != nil {
+		fmt.Println(redactSecrets(err.Error()))
+	}
+}
+
+// Подготовка БД на обоих серверах и передача данных с A на B. С opts.Resume продолжает
+// прерванный порционный запуск с последней зафиксированной порции
+func runTransfer(inv *Inventory, a, b participant, opts transferOptions) error {
+	coord, err := openCoordinatorLog(inv.coordinatorLogPath())
+	if err != nil {
+		return err
 	}
-	fmt.Println(simulateCrash)
+
+	runID := newRunID()
+	var checkpoint *transferCheckpoint
+	if opts.Resume != "" {
+		run, err := loadTransferRun(coord, opts.Resume)
+		if err != nil {
+			return err
+		}
+		if run.Options.ChunkSize == 0 {
+			return fmt.Errorf("Запуск %s выполнялся без разбиения на порции и не может быть возобновлён", opts.Resume)
+		}
+		if len(run.Unfinished) > 0 {
+			return fmt.Errorf("В запуске %s есть незавершённые транзакции (%s), выполните команду recover",
+				opts.Resume, strings.Join(run.Unfinished, ", "))
+		}
+		if a.Cluster.Name != run.Source || b.Cluster.Name != run.Destination {
+			return fmt.Errorf("Запуск %s передавал данные с %s на %s", opts.Resume, run.Source, run.Destination)
+		}
+		resumed := run.Options
+		resumed.SimulateCrash, resumed.SkipFixture, resumed.Resume = opts.SimulateCrash, true, opts.Resume
+		resumed.ConfigurePrepared, resumed.confirm = opts.ConfigurePrepared, opts.confirm
+		runID, opts, checkpoint = opts.Resume, resumed, run.Checkpoint
+	}
+
+	if err := opts.validate(time.Now()); err != nil {
+		return err
+	}
+	if opts.SimulateCrash {
+		if err := b.Cluster.managed(); err != nil {
+			return fmt.Errorf("Симуляция падения невозможна: %v", err)
+		}
+	}
+
+	// До подготовки БД и начала транзакций проверяется, что PREPARE TRANSACTION выполнится
+	if err := checkPreparedTransactions([]participant{a, b}, opts); err != nil {
+		return err
+	}
+
+	var wg sync.WaitGroup
 
 	// Заполнение таблиц
-	wg.Add(2)
-	go createDataBaseNTables(serverA, serverA, &wg)
-	go createDataBaseNTables(serverB, serverA, &wg)
-	wg.Wait()
+	if !opts.SkipFixture {
+		spec, err := opts.fixtureSpec()
+		if err != nil {
+			return err
+		}
+		var errA, errB error
+		wg.Add(2)
+		go createDataBaseNTables(a.Cluster.Name, a.Server, opts.database(), spec, opts.RecreateFixture, &errA, &wg)
+		go createDataBaseNTables(b.Cluster.Name, b.Server, opts.database(), nil, opts.RecreateFixture, &errB, &wg)
+		wg.Wait()
+		if err := errors.Join(errA, errB); err != nil {
+			return err
+		}
+	}
+
+	// Параметры запуска сохраняются для возобновления. Граница архивирования записывается
+	// абсолютным моментом, чтобы возобновлённый запуск отбирал те же строки
+	if opts.Resume == "" {
+		saved := opts
+		if opts.mode() == transferModeArchive {
+			saved.OlderThan = opts.cutoff.Format(time.RFC3339Nano)
+		}
+		if err := coord.write(coordinatorRecord{RunID: runID, State: coordStarted,
+			Source: a.Cluster.Name, Destination: b.Cluster.Name, Options: &saved}); err != nil {
+			return err
+		}
+	}
 
 	// Передача данных
-	wg.Add(1)
-	go transferDataWith2PC(serverA, serverB, simulateCrash, &wg)
-	wg.Wait()
+	opts.runID, opts.source, opts.destination = runID, a.Cluster.Name, b.Cluster.Name
+	fmt.Printf("Идентификатор запуска передачи: %s\n", runID)
+	if err := transferDataWith2PC(a, b, coord, runID, opts, checkpoint); err != nil {
+		return err
+	}
+
+	fmt.Printf("Все задачи выполнены (запуск %s)\n", runID)
+	return nil
+}
 
-	fmt.Println("Все задачи выполнены")
+// Имена таблиц для вывода в подсказках
+func (o transferOptions) tableNames() []string {
+	names := append([]string{}, o.Tables...)
+	if o.Schema != "" {
+		names = append(names, o.Schema+".*")
+	}
+	if len(names) == 0 {
+		names = []string{defaultTransferTable}
+	}
+	return names
 }
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/lib/pq"
+	"sort"
+	"strings"
+)
+
+// Число диапазонов ключа, на которые делится таблица при поиске расхождений
+const defaultVerifyRanges = 16
+
+// Таблицы не больше этого числа строк сравниваются построчно с выводом различающихся ключей
+const verifyKeyLimit = 1000
+
+// Сколько различающихся ключей выводится
+const verifyKeysShown = 20
+
+// Число строк и не зависящая от порядка строк контрольная сумма: сумма первых 64 бит
+// md5 текстового представления каждой строки
+type tableDigest struct {
+	Rows int64
+	Hash string
+}
+
+// Параметры сеанса, от которых зависит текстовое представление даты, времени, интервалов,
+// чисел с плавающей точкой и денежных сумм. Устанавливаются в транзакциях обоих серверов,
+// чтобы строки передавались и сверялись в одном представлении при разных настройках серверов
+const textSettings = "SET LOCAL TimeZone = 'UTC'; SET LOCAL DateStyle = 'ISO'; SET LOCAL IntervalStyle = 'postgres'; " +
+	"SET LOCAL extra_float_digits = 3; SET LOCAL lc_monetary = 'C'"
+
+// Установка параметров текстового представления значений в транзакции сервера server
+func pinTextSettings(tx *sql.Tx, server string) error {
+	// Запрос без параметров выполняется простым протоколом, поэтому команды передаются вместе
+	if _, err := tx.Exec(textSettings); err != nil {
+		return fmt.Errorf("Ошибка установки параметров сеанса на сервере %s: %v", server, err)
+	}
+	return nil
+}
+
+// Выражение md5 строки по передаваемым столбцам в текстовом представлении
+func rowHashExpr(t *tableInfo) string {
+	return "md5(ROW(" + columnList(t.dataColumns(), func(c columnInfo) string { return "::text" }) + ")::text)"
+}
+
+// Вычисление контрольной суммы строк таблицы, отобранных условием filter
+func digestTable(q queryer, t *tableInfo, filter string) (tableDigest, error) {
+	var d tableDigest
+	query := fmt.Sprintf("SELECT count(*), coalesce(sum(('x' || substr(%s, 1, 16))::bit(64)::bigint::numeric), 0)::text FROM %s%s",
+		rowHashExpr(t), t.qualified(), whereClause(filter))
+	if err := q.QueryRow(query).Scan(&d.Rows, &d.Hash); err != nil {
+		return d, fmt.Errorf("Ошибка вычисления контрольной суммы %s: %v", t, err)
+	}
+	return d, nil
+}
+
+// Диапазон ключа (Low, High]. Пустая граница - без ограничения
+type keyRange struct {
+	Low, High string
+}
+
+// Условие отбора строк диапазона
+func (r keyRange) restrict(filter string, key columnInfo) string {
+	conds := []string{}
+	if filter != "" {
+		conds = append(conds, filter)
+	}
+	name := pq.QuoteIdentifier(key.Name)
+	if r.Low != "" {
+		conds = append(conds, fmt.Sprintf("%s > %s::%s", name, pq.QuoteLiteral(r.Low), key.Type))
+	}
+	if r.High != "" {
+		conds = append(conds, fmt.Sprintf("%s <= %s::%s", name, pq.QuoteLiteral(r.High), key.Type))
+	}
+	return strings.Join(conds, " AND ")
+}
+
+func (r keyRange) String() string {
+	low, high := r.Low, r.High
+	if low == "" {
+		low = "-∞"
+	}
+	if high == "" {
+		high = "+∞"
+	}
+	return "(" + low + ", " + high + "]"
+}
+
+// Деление строк источника на n диапазонов ключа примерно равного размера.
+// Последний диапазон не ограничен сверху, чтобы в него попали лишние строки приёмника
+func keyRanges(q queryer, t *tableInfo, key columnInfo, filter string, n int) ([]keyRange, error) {
+	k := pq.QuoteIdentifier(key.Name)
+	rows, err := q.Query(fmt.Sprintf(`SELECT ((array_agg(k ORDER BY k DESC))[1])::text FROM (
+		SELECT %s AS k, ntile(%d) OVER (ORDER BY %s) AS bucket FROM %s%s) s
+		GROUP BY bucket ORDER BY bucket`, k, n, k, t.qualified(), whereClause(filter)))
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка деления %s на диапазоны: %v", t, err)
+	}
+	defer rows.Close()
+	var bounds []string
+	for rows.Next() {
+		var bound string
+		if err := rows.Scan(&bound); err != nil {
+			return nil, err
+		}
+		bounds = append(bounds, bound)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	ranges := []keyRange{}
+	low := ""
+	for i, bound := range bounds {
+		if i == len(bounds)-1 {
+			bound = ""
+		}
+		ranges = append(ranges, keyRange{Low: low, High: bound})
+		low = bound
+	}
+	if len(ranges) == 0 {
+		ranges = append(ranges, keyRange{})
+	}
+	return ranges, nil
+}
+
+// Хеши строк по ключу для построчного сравнения
+func rowHashes(q queryer, t *tableInfo, key columnInfo, filter string) (map[string]string, error) {
+	rows, err := q.Query(fmt.Sprintf("SELECT %s::text, %s FROM %s%s",
+		pq.QuoteIdentifier(key.Name), rowHashExpr(t), t.qualified(), whereClause(filter)))
+	if err != nil {
+		return nil, fmt.Errorf("Ошибка чтения строк %s: %v", t, err)
+	}
+	defer rows.Close()
+	hashes := make(map[string]string)
+	for rows.Next() {
+		var k, h string
+		if err := rows.Scan(&k, &h); err != nil {
+			return nil, err
+		}
+		hashes[k] = h
+	}
+	return hashes, rows.Err()
+}
+
+// Результат сверки таблицы
+type verifyResult struct {
+	Table       string
+	Source      tableDigest
+	Destination tableDigest
+	Ranges      []string // Описания различающихся диапазонов ключа
+	Keys        []string // Описания различающихся строк
+}
+
+func (r verifyResult) ok() bool {
+	return r.Source == r.Destination
+}
+
+// Вывод результата сверки
+func (r verifyResult) print() {
+	if r.ok() {
+		fmt.Printf("Таблица %s: совпадает (строк %d)\n", r.Table, r.Source.Rows)
+		return
+	}
+	fmt.Printf("Таблица %s: РАСХОЖДЕНИЕ, строк на источнике %d, на приёмнике %d\n", r.Table, r.Source.Rows, r.Destination.Rows)
+	for _, line := range r.Ranges {
+		fmt.Println("  " + line)
+	}
+	for _, line := range r.Keys {
+		fmt.Println("  " + line)
+	}
+}
+
+// Сверка таблицы t на источнике qa и приёмнике qb: на источнике строки, отобранные условием
+// filter, на приёмнике - условием dstFilter. При расхождении ищутся
+// различающиеся диапазоны ключа, а для небольших таблиц - ключи строк
+func verifyTable(qa, qb queryer, t *tableInfo, opts transferOptions, filter, dstFilter string) (verifyResult, error) {
+	result := verifyResult{Table: t.String()}
+	var err error
+	if result.Source, err = digestTable(qa, t, filter); err != nil {
+		return result, err
+	}
+	if result.Destination, err = digestTable(qb, t, dstFilter); err != nil {
+		return result, err
+	}
+	if result.ok() {
+		return result, nil
+	}
+	key, err := opts.keyColumn(t)
+	if err != nil {
+		// Без ключа из одного столбца расхождение можно указать только для таблицы целиком
+		return result, nil
+	}
+
+	if result.Source.Rows <= verifyKeyLimit && result.Destination.Rows <= verifyKeyLimit {
+		result.Keys, err = diffKeys(qa, qb, t, key, filter, dstFilter)
+		return result, err
+	}
+	ranges, err := keyRanges(qa, t, key, filter, opts.verifyRanges())
+	if err != nil {
+		return result, err
+	}
+	for _, r := range ranges {
+		a, err := digestTable(qa, t, r.restrict(filter, key))
+		if err != nil {
+			return result, err
+		}
+		b, err := digestTable(qb, t, r.restrict(dstFilter, key))
+		if err != nil {
+			return result, err
+		}
+		if a != b {
+			result.Ranges = append(result.Ranges, fmt.Sprintf("диапазон %s %s: строк на источнике %d, на приёмнике %d",
+				key.Name, r, a.Rows, b.Rows))
+		}
+	}
+	return result, nil
+}
+
+// Построчное сравнение по ключу: строки, которых нет на приёмнике, лишние строки и различающиеся
+func diffKeys(qa, qb queryer, t *tableInfo, key columnInfo, filter, dstFilter string) ([]string, error) {
+	a, err := rowHashes(qa, t, key, filter)
+	if err != nil {
+		return nil, err
+	}
+	b, err := rowHashes(qb, t, key, dstFilter)
+	if err != nil {
+		return nil, err
+	}
+	var diffs []string
+	for k, h := range a {
+		hb, found := b[k]
+		switch {
+		case !found:
+			diffs = append(diffs, fmt.Sprintf("%s = %s: нет на приёмнике", key.Name, k))
+		case hb != h:
+			diffs = append(diffs, fmt.Sprintf("%s = %s: строки различаются", key.Name, k))
+		}
+	}
+	for k := range b {
+		if _, found := a[k]; !found {
+			diffs = append(diffs, fmt.Sprintf("%s = %s: нет на источнике", key.Name, k))
+		}
+	}
+	sort.Strings(diffs)
+	if len(diffs) > verifyKeysShown {
+		diffs = append(diffs[:verifyKeysShown], fmt.Sprintf("... и ещё %d", len(diffs)-verifyKeysShown))
+	}
+	return diffs, nil
+}
+
+// Условие отбора на приёмнике строк, записанных транзакцией передачи: у вставленных
+// и заменённых ею версий строк xmin равен номеру текущей транзакции. Строки приёмника,
+// которых не было в передаче, не сверяются, а сами строки между серверами не пересылаются
+func copiedRowsFilter(filter string) string {
+	cond := "xmin::text = (txid_current() % 4294967296)::text"
+	if filter != "" {
+		cond = filter + " AND " + cond
+	}
+	return cond
+}
+
+// Сверка переданных таблиц внутри транзакций передачи: снимок источника до удаления строк
+// сравнивается с переданными строками приёмника. chunk - порция единственной таблицы (nil -
+// все строки). Таблицы, в которых конфликтующие строки приёмника сохранены, не сверяются
+func verifyTransferred(txA, txB *sql.Tx, tables []*tableInfo, opts transferOptions, chunk *tableChunk, stats map[string]tableStats) error {
+	var failed []string
+	for _, t := range tables {
+		if s := stats[t.String()]; s.Skipped+s.Diverted > 0 {
+			// Строки приёмника намеренно отличаются от источника
+			fmt.Printf("Таблица %s: сверка пропущена, на приёмнике оставлены конфликтующие строки\n", t)
+			continue
+		}
+		filter, err := opts.tableFilter(t)
+		if err != nil {
+			return err
+		}
+		if chunk != nil {
+			if chunk.Last == "" {
+				continue
+			}
+			filter = chunk.restrict(filter, true)
+		}
+		result, err := verifyTable(txA, txB, t, opts, filter, copiedRowsFilter(filter))
+		if err != nil {
+			return err
+		}
+		result.print()
+		if !result.ok() {
+			failed = append(failed, t.String())
+		}
+	}
+	if len(failed) > 0 {
+		return newTransferError(phaseVerify, "", fmt.Errorf("Сверка не пройдена для таблиц: %s", strings.Join(failed, ", ")))
+	}
+	return nil
+}
+
+// Сверка таблиц между серверами A и B (команда verify). Каждая сторона читается
+// в транзакции REPEATABLE READ только для чтения, чтобы сравнивались согласованные снимки
+func verifyServers(serverA, serverB string, opts transferOptions) error {
+	open := func(server, name string) (*sql.DB, *sql.Tx, error) {
+		db, err := openDB(server, opts.database())
+		if err != nil {
+			return nil, nil, fmt.Errorf("Ошибка подключения к серверу %s: %v", name, err)
+		}
+		tx, err := db.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+		if err != nil {
+			db.Close()
+			return nil, nil, fmt.Errorf("Ошибка начала транзакции на сервере %s: %v", name, err)
+		}
+		if err := pinTextSettings(tx, name); err != nil {
+			tx.Rollback()
+			db.Close()
+			return nil, nil, err
+		}
+		return db, tx, nil
+	}
+	dbA, txA, err := open(serverA, "A")
+	if err != nil {
+		return err
+	}
+	defer dbA.Close()
+	defer txA.Rollback()
+	dbB, txB, err := open(serverB, "B")
+	if err != nil {
+		return err
+	}
+	defer dbB.Close()
+	defer txB.Rollback()
+
+	tables, err := resolveTables(txA, opts)
+	if err != nil {
+		return err
+	}
+	var failed []string
+	for _, t := range tables {
+		dst, err := introspectTable(txB, t.Schema, t.Name)
+		if err != nil {
+			return err
+		}
+		if dst == nil {
+			fmt.Printf("Таблица %s: нет на приёмнике\n", t)
+			failed = append(failed, t.String())
+			continue
+		}
+		filter, err := opts.tableFilter(t)
+		if err != nil {
+			return err
+		}
+		result, err := verifyTable(txA, txB, t, opts, filter, filter)
+		if err != nil {
+			return err
+		}
+		result.print()
+		if !result.ok() {
+			failed = append(failed, t.String())
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("Сверка не пройдена для таблиц: %s", strings.Join(failed, ", "))
+	}
+	fmt.Println("Сверка пройдена")
+	return nil
+}
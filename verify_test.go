@@ -0,0 +1,62 @@
+package main
+
+import "testing"
+
+func TestKeyRangeRestrict(t *testing.T) {
+	id := columnInfo{Name: "id", Type: "bigint"}
+	created := columnInfo{Name: "Created At", Type: "timestamp with time zone"}
+	tests := []struct {
+		name   string
+		r      keyRange
+		filter string
+		key    columnInfo
+		want   string
+	}{
+		{"unbounded", keyRange{}, "", id, ""},
+		{"unbounded keeps filter", keyRange{}, "(status = 'new')", id, "(status = 'new')"},
+		{"low only", keyRange{Low: "10"}, "", id, `"id" > '10'::bigint`},
+		{"high only", keyRange{High: "20"}, "", id, `"id" <= '20'::bigint`},
+		{"both with filter", keyRange{Low: "10", High: "20"}, "(status = 'new')", id,
+			`(status = 'new') AND "id" > '10'::bigint AND "id" <= '20'::bigint`},
+		{"quoted key and value", keyRange{Low: "2024-01-01 00:00:00+00", High: "it's"}, "", created,
+			`"Created At" > '2024-01-01 00:00:00+00'::timestamp with time zone AND "Created At" <= 'it''s'::timestamp with time zone`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.r.restrict(tt.filter, tt.key); got != tt.want {
+				t.Errorf("restrict = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeyRangeString(t *testing.T) {
+	tests := []struct {
+		r    keyRange
+		want string
+	}{
+		{keyRange{}, "(-∞, +∞]"},
+		{keyRange{Low: "1", High: "5"}, "(1, 5]"},
+		{keyRange{Low: "5"}, "(5, +∞]"},
+	}
+	for _, tt := range tests {
+		if got := tt.r.String(); got != tt.want {
+			t.Errorf("%+v.String() = %q, want %q", tt.r, got, tt.want)
+		}
+	}
+}
+
+func TestCopiedRowsFilter(t *testing.T) {
+	const own = "xmin::text = (txid_current() % 4294967296)::text"
+	tests := []struct {
+		filter, want string
+	}{
+		{"", own},
+		{`(status = 'new') AND "id" <= '200'::integer`, `(status = 'new') AND "id" <= '200'::integer AND ` + own},
+	}
+	for _, tt := range tests {
+		if got := copiedRowsFilter(tt.filter); got != tt.want {
+			t.Errorf("copiedRowsFilter(%q) = %q, want %q", tt.filter, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Время ожидания запуска и остановки сервера по умолчанию
+const defaultClusterTimeout = 60 * time.Second
+
+// Количество строк журнала сервера, выводимых при ошибке
+const logTailLines = 20
+
+// Режимы остановки pg_ctl stop -m
+const (
+	stopSmart     = "smart"     // Ждать отключения всех клиентов
+	stopFast      = "fast"      // Откатить активные транзакции и отключить клиентов
+	stopImmediate = "immediate" // Аварийная остановка без контрольной точки
+)
+
+// Проверка режима остановки
+func validStopMode(mode string) error {
+	switch mode {
+	case stopSmart, stopFast, stopImmediate:
+		return nil
+	}
+	return fmt.Errorf("Некорректный режим остановки %q: допустимы %s, %s, %s", mode, stopSmart, stopFast, stopImmediate)
+}
+
+// Время ожидания запуска и остановки кластера
+func (c Cluster) timeout() time.Duration {
+	if c.Timeout > 0 {
+		return time.Duration(c.Timeout) * time.Second
+	}
+	return defaultClusterTimeout
+}
+
+// Путь к журналу сервера, в который pg_ctl перенаправляет вывод postgres
+func (c Cluster) logFile() string {
+	if c.LogFile != "" {
+		return c.LogFile
+	}
+	return filepath.Join(c.DataDir, "server.log")
+}
+
+// Последние строки журнала сервера для сообщений об ошибках
+func logTail(path string, n int) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+		if len(lines) > n {
+			lines = lines[1:]
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Добавление хвоста журнала сервера к ошибке
+func withLogTail(err error, cluster Cluster) error {
+	tail := logTail(cluster.logFile(), logTailLines)
+	if tail == "" {
+		return err
+	}
+	return fmt.Errorf("%v\nПоследние строки журнала %s:\n%s", err, cluster.logFile(), tail)
+}
+
+// Выполнение pg_ctl с ожиданием завершения. Запущенный postgres может унаследовать
+// дескрипторы вывода, поэтому ожидание вывода после выхода pg_ctl ограничено
+func runPgCtl(cmd *exec.Cmd) (string, error) {
+	cmd.WaitDelay = 5 * time.Second
+	output, err := cmd.CombinedOutput()
+	if errors.Is(err, exec.ErrWaitDelay) {
+		err = nil
+	}
+	decodedOutput, decodeErr := decodeOutput(output)
+	if decodeErr != nil {
+		decodedOutput = string(output)
+	}
+	return strings.TrimSpace(decodedOutput), err
+}
+
+// Адрес для проверки готовности сервера: Unix-сокет, если хост задан каталогом, иначе TCP host:port
+func readyAddress(cluster Cluster, status ClusterStatus) (network, address string) {
+	port := cluster.Port
+	if status.Port != 0 {
+		port = status.Port
+	}
+	if isSocketDir(cluster.Host) {
+		return "unix", filepath.Join(cluster.Host, ".s.PGSQL."+strconv.Itoa(port))
+	}
+	return "tcp", net.JoinHostPort(cluster.Host, strconv.Itoa(port))
+}
+
+// Ожидание, пока сервер не начнёт принимать подключения
+func waitForReady(cluster Cluster, deadline time.Time) error {
+	for {
+		status, err := getClusterStatus(cluster)
+		if err != nil {
+			return err
+		}
+		if status.State == StateStopped || status.State == StateStalePid {
+			return fmt.Errorf("Сервер %s завершил работу во время запуска", cluster.Name)
+		}
+		if status.State == StateRunning {
+			network, address := readyAddress(cluster, status)
+			conn, err := net.DialTimeout(network, address, time.Second)
+			if err == nil {
+				conn.Close()
+				return nil
+			}
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("Сервер %s не начал принимать подключения за %s (состояние: %s)", cluster.Name, cluster.timeout(), status.State)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// Ожидание полной остановки сервера
+func waitForStopped(cluster Cluster, deadline time.Time) error {
+	for {
+		status, err := getClusterStatus(cluster)
+		if err != nil {
+			return err
+		}
+		if !status.State.alive() {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("Сервер %s не остановился за %s (состояние: %s)", cluster.Name, cluster.timeout(), status.State)
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}